@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/kopia/kopia/storage/webdav"
+	"github.com/kopia/kopia/storage/webdav/webdavauth"
+)
+
+// repositoryCommand groups subcommands that create or connect to a
+// repository in a particular storage backend.
+var (
+	repositoryCommand = app.Command("repository", "Create or connect to a repository.")
+	createCommand     = repositoryCommand.Command("create", "Create a new repository in a storage location.")
+	connectCommand    = repositoryCommand.Command("connect", "Connect to an existing repository in a storage location.")
+)
+
+func setupWebDAVFlags(cmd *kingpin.CmdClause) func() *webdav.Options {
+	var (
+		url      = cmd.Flag("url", "URL of the WebDAV server").Required().String()
+		username = cmd.Flag("webdav-username", "Username for HTTP Basic/Digest authentication").String()
+		password = cmd.Flag("webdav-password", "Password for HTTP Basic/Digest authentication").String()
+
+		authType = cmd.Flag("webdav-auth", "Authentication scheme: none, basic, digest, bearer or oauth2").Default("none").Enum(
+			"none",
+			string(webdavauth.TypeBasic),
+			string(webdavauth.TypeDigest),
+			string(webdavauth.TypeBearer),
+			string(webdavauth.TypeOAuth2),
+		)
+		bearerToken = cmd.Flag("webdav-bearer-token", "Bearer token, used when --webdav-auth=bearer").String()
+
+		oauth2TokenURL     = cmd.Flag("webdav-oauth2-token-url", "OAuth2 token endpoint, used when --webdav-auth=oauth2").String()
+		oauth2ClientID     = cmd.Flag("webdav-oauth2-client-id", "OAuth2 client ID").String()
+		oauth2ClientSecret = cmd.Flag("webdav-oauth2-client-secret", "OAuth2 client secret").String()
+		oauth2RefreshToken = cmd.Flag("webdav-oauth2-refresh-token", "OAuth2 refresh token (selects the refresh-token grant instead of client-credentials)").String()
+		oauth2Scopes       = cmd.Flag("webdav-oauth2-scope", "OAuth2 scope, may be repeated").Strings()
+	)
+
+	return func() *webdav.Options {
+		var auth *webdavauth.Options
+
+		if *authType != "none" {
+			auth = &webdavauth.Options{
+				Type:     webdavauth.Type(*authType),
+				Username: *username,
+				Password: *password,
+				Token:    *bearerToken,
+			}
+
+			if webdavauth.Type(*authType) == webdavauth.TypeOAuth2 {
+				auth.OAuth2 = &webdavauth.OAuth2Options{
+					TokenURL:     *oauth2TokenURL,
+					ClientID:     *oauth2ClientID,
+					ClientSecret: *oauth2ClientSecret,
+					RefreshToken: *oauth2RefreshToken,
+					Scopes:       *oauth2Scopes,
+				}
+			}
+		}
+
+		return &webdav.Options{
+			URL:  *url,
+			Auth: auth,
+		}
+	}
+}
+
+var (
+	connectWebDAVCommand        = connectCommand.Command("webdav", "Connect to repository in a WebDAV-based storage.")
+	connectWebDAVOptionsFromCmd = setupWebDAVFlags(connectWebDAVCommand)
+
+	createWebDAVCommand        = createCommand.Command("webdav", "Create repository in a WebDAV-based storage.")
+	createWebDAVOptionsFromCmd = setupWebDAVFlags(createWebDAVCommand)
+)
+
+func init() {
+	connectWebDAVCommand.Action(func(kctx *kingpin.ParseContext) error {
+		ctx := context.Background()
+
+		if _, err := webdav.New(ctx, connectWebDAVOptionsFromCmd()); err != nil {
+			return fmt.Errorf("unable to connect to WebDAV storage: %w", err)
+		}
+
+		return nil
+	})
+
+	createWebDAVCommand.Action(func(kctx *kingpin.ParseContext) error {
+		ctx := context.Background()
+
+		if _, err := webdav.New(ctx, createWebDAVOptionsFromCmd()); err != nil {
+			return fmt.Errorf("unable to create WebDAV storage: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kopia/kopia/internal/serverapi"
+)
+
+// mountTokenTTL is how long a URL returned by MountHandler.ServeMount stays
+// valid if it isn't explicitly unmounted first.
+const mountTokenTTL = 24 * time.Hour
+
+// MountHandler implements the server side of Client.MountWebDAV and
+// Client.UnmountWebDAV: it mints a read-only, source/snapshot-scoped access
+// token and bakes it into a self-contained WebDAV URL, so that any
+// WebDAV-capable file manager can browse the snapshot without separately
+// provisioning credentials.
+type MountHandler struct {
+	Tokens *TokenHandler
+
+	// BaseURL is the externally-reachable origin (e.g.
+	// "https://kopia.example.com") the WebDAVHandler is served from, with no
+	// trailing slash. Mount URLs are built by appending the snapshot path to
+	// it.
+	BaseURL string
+}
+
+// ServeMount implements POST /dav/mount: it mints a token scoped to the
+// requested source/snapshot and returns a URL a WebDAV client can mount
+// directly, with the token embedded as an access_token query parameter.
+func (h *MountHandler) ServeMount(w http.ResponseWriter, r *http.Request) {
+	var req serverapi.MountWebDAVRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scope := serverapi.TokenScope{ReadOnly: true, SourcePath: req.SourcePath, SnapshotID: req.SnapshotID}
+
+	token, err := h.Tokens.mint(scope, mountTokenTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	mountURL := fmt.Sprintf("%s%s%s/%s?access_token=%s",
+		strings.TrimSuffix(h.BaseURL, "/"),
+		snapshotsPrefix,
+		url.PathEscape(req.SourcePath),
+		url.PathEscape(req.SnapshotID),
+		url.QueryEscape(token))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&serverapi.MountWebDAVResponse{URL: mountURL}) //nolint:errcheck
+}
+
+// ServeUnmount implements POST /dav/unmount: it revokes the access token
+// embedded in a URL previously returned by ServeMount, so the URL stops
+// working immediately instead of lingering until it expires on its own.
+func (h *MountHandler) ServeUnmount(w http.ResponseWriter, r *http.Request) {
+	var req serverapi.UnmountWebDAVRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := mountTokenFromURL(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.Tokens.revoke(token)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&serverapi.Empty{}) //nolint:errcheck
+}
+
+// mountTokenFromURL extracts the access_token query parameter a mount URL
+// was minted with.
+func mountTokenFromURL(mountURL string) (string, error) {
+	parsed, err := url.Parse(mountURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid mount URL: %w", err)
+	}
+
+	token := parsed.Query().Get("access_token")
+	if token == "" {
+		return "", errors.New("URL has no access_token to revoke")
+	}
+
+	return token, nil
+}
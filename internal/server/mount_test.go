@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kopia/kopia/internal/serverapi"
+)
+
+func doMountRequest(t *testing.T, handler http.HandlerFunc, req interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("unable to marshal request: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+
+	return rec
+}
+
+// TestMountHandlerRoundTrip verifies that the URL returned by ServeMount
+// carries a token that authorizes exactly the requested source/snapshot,
+// and that ServeUnmount revokes it so the same URL stops working.
+func TestMountHandlerRoundTrip(t *testing.T) {
+	h := &MountHandler{Tokens: &TokenHandler{SigningKey: []byte("test-signing-key")}, BaseURL: "https://kopia.example.com"}
+
+	rec := doMountRequest(t, h.ServeMount, &serverapi.MountWebDAVRequest{SourcePath: "/home/alice", SnapshotID: "snap1"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ServeMount returned status %v: %s", rec.Code, rec.Body)
+	}
+
+	var mountResp serverapi.MountWebDAVResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &mountResp); err != nil {
+		t.Fatalf("unable to unmarshal mount response: %v", err)
+	}
+
+	mountedReq := httptest.NewRequest(http.MethodGet, mountResp.URL, nil)
+	if err := h.Tokens.Authorize(mountedReq, "/home/alice", "snap1", ""); err != nil {
+		t.Fatalf("expected mounted URL to authorize its own source/snapshot, got %v", err)
+	}
+
+	if err := h.Tokens.Authorize(mountedReq, "/home/bob", "snap1", ""); err == nil {
+		t.Fatal("expected mounted URL to be denied for a different source path")
+	}
+
+	writeReq := httptest.NewRequest(http.MethodPut, mountResp.URL, nil)
+	if err := h.Tokens.Authorize(writeReq, "/home/alice", "snap1", ""); err == nil {
+		t.Fatal("expected mounted URL to be denied for a non-read-only method")
+	}
+
+	unmountRec := doMountRequest(t, h.ServeUnmount, &serverapi.UnmountWebDAVRequest{URL: mountResp.URL})
+	if unmountRec.Code != http.StatusOK {
+		t.Fatalf("ServeUnmount returned status %v: %s", unmountRec.Code, unmountRec.Body)
+	}
+
+	if err := h.Tokens.Authorize(mountedReq, "/home/alice", "snap1", ""); err == nil {
+		t.Fatal("expected revoked mount URL to no longer be authorized")
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kopia/kopia/internal/serverapi"
+)
+
+// TokenSigningKey is the HMAC key used to sign and verify federated access
+// tokens. It is derived from the repository's master key, so a token minted
+// by one server instance remains valid against any other server connected
+// to the same repository, and is invalidated the moment the repository's
+// master key is rotated.
+type TokenSigningKey []byte
+
+type tokenClaims struct {
+	Scope     serverapi.TokenScope `json:"scope"`
+	ExpiresAt int64                `json:"exp"`
+}
+
+// TokenHandler mints and verifies federated access tokens scoped to a
+// single repository operation (read-only, one source, one snapshot, or one
+// object), so a kopia server can hand out narrow, time-limited credentials
+// to another process without sharing full operator access.
+type TokenHandler struct {
+	SigningKey TokenSigningKey
+
+	mu      sync.Mutex
+	revoked map[string]struct{}
+}
+
+// revoke marks token as no longer valid, regardless of its expiry. Used by
+// MountHandler to implement UnmountWebDAV, since a minted token otherwise
+// remains valid until it expires on its own.
+func (h *TokenHandler) revoke(token string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.revoked == nil {
+		h.revoked = map[string]struct{}{}
+	}
+
+	h.revoked[token] = struct{}{}
+}
+
+func (h *TokenHandler) isRevoked(token string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, ok := h.revoked[token]
+
+	return ok
+}
+
+func (h *TokenHandler) mint(scope serverapi.TokenScope, ttl time.Duration) (string, error) {
+	claims := tokenClaims{Scope: scope, ExpiresAt: time.Now().Add(ttl).Unix()}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal token claims: %w", err)
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encodedPayload + "." + h.sign(encodedPayload), nil
+}
+
+func (h *TokenHandler) sign(encodedPayload string) string {
+	mac := hmac.New(sha256.New, h.SigningKey)
+	mac.Write([]byte(encodedPayload)) //nolint:errcheck
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify parses and validates a token previously minted by mint, checking
+// both its signature and expiry.
+func (h *TokenHandler) verify(token string) (*tokenClaims, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed token")
+	}
+
+	encodedPayload, sig := parts[0], parts[1]
+
+	if !hmac.Equal([]byte(h.sign(encodedPayload)), []byte(sig)) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token payload: %w", err)
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("invalid token payload: %w", err)
+	}
+
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, errors.New("token expired")
+	}
+
+	if h.isRevoked(token) {
+		return nil, errors.New("token has been revoked")
+	}
+
+	return &claims, nil
+}
+
+// ServeMintToken implements POST /tokens, minting a token scoped per the
+// request body and signed with SigningKey.
+func (h *TokenHandler) ServeMintToken(w http.ResponseWriter, r *http.Request) {
+	var req serverapi.MintTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.mint(req.Scope, req.TTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&serverapi.MintTokenResponse{Token: token}) //nolint:errcheck
+}
+
+// isSafeMethod reports whether method is a read-only HTTP/WebDAV method,
+// i.e. one a ReadOnly-scoped token is permitted to perform.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return true
+	default:
+		return false
+	}
+}
+
+// bearerToken extracts the federated access token from r, accepting either
+// a standard "Authorization: Bearer" header or an "access_token" query
+// parameter. The query parameter exists for WebDAV mount URLs (see
+// MountHandler): WebDAV clients mount a plain URL and generally can't be
+// configured to send a custom header.
+func bearerToken(r *http.Request) (string, bool) {
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer "), true
+	}
+
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token, true
+	}
+
+	return "", false
+}
+
+// Authorize verifies the bearer token on r authorizes an operation against
+// the given source path, snapshot ID and/or object ID (pass "" for any
+// dimension that doesn't apply). When h.SigningKey is empty, federated
+// tokens are disabled entirely and every request is allowed through,
+// preserving the server's original unauthenticated behavior.
+func (h *TokenHandler) Authorize(r *http.Request, sourcePath, snapshotID, objectID string) error {
+	if len(h.SigningKey) == 0 {
+		return nil
+	}
+
+	token, ok := bearerToken(r)
+	if !ok {
+		return errors.New("missing bearer token")
+	}
+
+	claims, err := h.verify(token)
+	if err != nil {
+		return err
+	}
+
+	if claims.Scope.ReadOnly && !isSafeMethod(r.Method) {
+		return errors.New("token is read-only")
+	}
+
+	if !claims.Scope.Authorizes(sourcePath, snapshotID, objectID) {
+		return errors.New("token does not authorize this request")
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/serverapi"
+)
+
+func mustMintToken(t *testing.T, h *TokenHandler, scope serverapi.TokenScope, ttl time.Duration) string {
+	t.Helper()
+
+	token, err := h.mint(scope, ttl)
+	if err != nil {
+		t.Fatalf("mint failed: %v", err)
+	}
+
+	return token
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/dav/objects/deadbeef/name", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req
+}
+
+// A token scoped to a source/snapshot must not authorize object access: the
+// object endpoint has no source path or snapshot ID to check it against, and
+// treating the missing value as a match would turn the token into a skeleton
+// key for every object in the repository.
+func TestTokenHandlerSourceScopedTokenDeniesObjectAccess(t *testing.T) {
+	h := &TokenHandler{SigningKey: []byte("test-signing-key")}
+
+	token := mustMintToken(t, h, serverapi.TokenScope{SourcePath: "/home/alice", SnapshotID: "snap1"}, time.Hour)
+
+	if err := h.Authorize(bearerRequest(token), "", "", "deadbeef"); err == nil {
+		t.Fatal("expected source/snapshot-scoped token to be denied object access")
+	}
+
+	if err := h.Authorize(bearerRequest(token), "/home/alice", "snap1", ""); err != nil {
+		t.Fatalf("expected matching source/snapshot to be authorized, got %v", err)
+	}
+
+	if err := h.Authorize(bearerRequest(token), "/home/bob", "snap1", ""); err == nil {
+		t.Fatal("expected mismatched source path to be denied")
+	}
+}
+
+func TestTokenHandlerObjectScopedToken(t *testing.T) {
+	h := &TokenHandler{SigningKey: []byte("test-signing-key")}
+
+	token := mustMintToken(t, h, serverapi.TokenScope{ObjectID: "deadbeef"}, time.Hour)
+
+	if err := h.Authorize(bearerRequest(token), "", "", "deadbeef"); err != nil {
+		t.Fatalf("expected matching object to be authorized, got %v", err)
+	}
+
+	if err := h.Authorize(bearerRequest(token), "", "", "othervalue"); err == nil {
+		t.Fatal("expected mismatched object to be denied")
+	}
+
+	// An object-scoped token doesn't carry any source/snapshot restriction,
+	// but it still can't authorize a snapshot-path request since it can't
+	// prove the object belongs to the requested snapshot.
+	if err := h.Authorize(bearerRequest(token), "/home/alice", "snap1", ""); err == nil {
+		t.Fatal("expected object-scoped token to be denied snapshot path access")
+	}
+}
+
+// A ReadOnly-scoped token must be denied for any request using a method
+// that isn't safe/read-only, even one that would otherwise match the
+// scope's source/snapshot/object restrictions.
+func TestTokenHandlerReadOnlyScopeDeniesWriteMethod(t *testing.T) {
+	h := &TokenHandler{SigningKey: []byte("test-signing-key")}
+
+	token := mustMintToken(t, h, serverapi.TokenScope{ReadOnly: true, ObjectID: "deadbeef"}, time.Hour)
+
+	req := bearerRequest(token)
+	req.Method = http.MethodPut
+
+	if err := h.Authorize(req, "", "", "deadbeef"); err == nil {
+		t.Fatal("expected read-only token to be denied for a PUT request")
+	}
+
+	if err := h.Authorize(bearerRequest(token), "", "", "deadbeef"); err != nil {
+		t.Fatalf("expected read-only token to be authorized for a GET request, got %v", err)
+	}
+}
+
+func TestTokenHandlerExpiredToken(t *testing.T) {
+	h := &TokenHandler{SigningKey: []byte("test-signing-key")}
+
+	token := mustMintToken(t, h, serverapi.TokenScope{}, -time.Minute)
+
+	if err := h.Authorize(bearerRequest(token), "", "", "deadbeef"); err == nil {
+		t.Fatal("expected expired token to be denied")
+	}
+}
+
+func TestTokenHandlerDisabledWhenNoSigningKey(t *testing.T) {
+	h := &TokenHandler{}
+
+	if err := h.Authorize(bearerRequest(""), "", "", "deadbeef"); err != nil {
+		t.Fatalf("expected handler with no signing key to allow all requests, got %v", err)
+	}
+}
+
+func TestTokenHandlerRejectsMissingOrMalformedBearer(t *testing.T) {
+	h := &TokenHandler{SigningKey: []byte("test-signing-key")}
+
+	if err := h.Authorize(bearerRequest(""), "", "", "deadbeef"); err == nil {
+		t.Fatal("expected missing bearer token to be denied")
+	}
+
+	tampered := mustMintToken(t, h, serverapi.TokenScope{ObjectID: "deadbeef"}, time.Hour) + "x"
+	if err := h.Authorize(bearerRequest(tampered), "", "", "deadbeef"); err == nil {
+		t.Fatal("expected tampered token to be denied")
+	}
+}
+
+// TestTokenScopeFailsClosedOnUncheckableDimension catches the specific bypass this commit
+// fixes at the TokenScope level, independent of the HTTP plumbing: an
+// ObjectID-only scope must not authorize a request that can't supply an
+// object ID, and vice versa for SourcePath/SnapshotID.
+func TestTokenScopeFailsClosedOnUncheckableDimension(t *testing.T) {
+	objectScope := serverapi.TokenScope{ObjectID: "deadbeef"}
+	if objectScope.Authorizes("/home/alice", "snap1", "") {
+		t.Fatal("object-scoped token should not authorize a request with no object ID")
+	}
+
+	sourceScope := serverapi.TokenScope{SourcePath: "/home/alice"}
+	if sourceScope.Authorizes("", "", "deadbeef") {
+		t.Fatal("source-scoped token should not authorize a request with no source path")
+	}
+}
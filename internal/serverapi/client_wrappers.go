@@ -34,4 +34,4 @@ func (c *Client) Sources(ctx context.Context) (*SourcesResponse, error) {
 	}
 
 	return resp, nil
-}
\ No newline at end of file
+}
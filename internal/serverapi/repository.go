@@ -0,0 +1,36 @@
+package serverapi
+
+import "encoding/json"
+
+// CreateRequest asks the server to create a new repository backed by the
+// given storage and protect it with password.
+type CreateRequest struct {
+	Storage  string          `json:"storage"`
+	Config   json.RawMessage `json:"config"`
+	Password string          `json:"password"`
+}
+
+// ConnectRequest asks the server to connect to an existing repository
+// backed by the given storage.
+type ConnectRequest struct {
+	Storage  string          `json:"storage"`
+	Config   json.RawMessage `json:"config"`
+	Password string          `json:"password"`
+}
+
+// StatusResponse reports whether the server is currently connected to a
+// repository and, if so, which config file it loaded.
+type StatusResponse struct {
+	Connected  bool   `json:"connected"`
+	ConfigFile string `json:"configFile,omitempty"`
+}
+
+// SourcesResponse lists the snapshot sources known to the connected
+// repository.
+type SourcesResponse struct {
+	Sources []string `json:"sources"`
+}
+
+// Empty is used for endpoints that take or return no meaningful payload,
+// such as disconnect and shutdown.
+type Empty struct{}
@@ -0,0 +1,39 @@
+package serverapi
+
+import "context"
+
+// MountWebDAVRequest requests a read-only WebDAV URL for browsing a single
+// snapshot's directory tree.
+type MountWebDAVRequest struct {
+	SourcePath string `json:"sourcePath"`
+	SnapshotID string `json:"snapshotID"`
+}
+
+// MountWebDAVResponse carries the URL a WebDAV-capable file manager (Windows
+// Explorer, Finder, nautilus) can be pointed at to browse the requested
+// snapshot.
+type MountWebDAVResponse struct {
+	URL string `json:"url"`
+}
+
+// UnmountWebDAVRequest identifies a previously-mounted URL to revoke.
+type UnmountWebDAVRequest struct {
+	URL string `json:"url"`
+}
+
+// MountWebDAV asks the server to authorize WebDAV access to the given
+// snapshot and returns the URL to mount, with authentication already baked
+// in so the caller doesn't need to separately provision WebDAV credentials.
+func (c *Client) MountWebDAV(ctx context.Context, req *MountWebDAVRequest) (*MountWebDAVResponse, error) {
+	resp := &MountWebDAVResponse{}
+	if err := c.Post("dav/mount", req, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// UnmountWebDAV revokes a URL previously returned by MountWebDAV.
+func (c *Client) UnmountWebDAV(ctx context.Context, url string) error {
+	return c.Post("dav/unmount", &UnmountWebDAVRequest{URL: url}, &Empty{})
+}
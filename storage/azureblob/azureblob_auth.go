@@ -0,0 +1,150 @@
+package azureblob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// authorizer adds the credentials configured in Options to an outgoing
+// request, just before it is sent.
+type authorizer interface {
+	authorize(req *http.Request) error
+}
+
+func newAuthorizer(opts *Options) (authorizer, error) {
+	switch {
+	case opts.SASToken != "":
+		return &sasAuthorizer{token: strings.TrimPrefix(opts.SASToken, "?")}, nil
+
+	case opts.StorageKey != "":
+		key, err := base64.StdEncoding.DecodeString(opts.StorageKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage key: %v", err)
+		}
+
+		return &sharedKeyAuthorizer{account: opts.StorageAccount, key: key}, nil
+
+	default:
+		return nil, fmt.Errorf("one of StorageKey or SASToken must be set")
+	}
+}
+
+// sasAuthorizer appends a pre-signed shared access signature to the request
+// query string.
+type sasAuthorizer struct {
+	token string
+}
+
+func (a *sasAuthorizer) authorize(req *http.Request) error {
+	if req.URL.RawQuery == "" {
+		req.URL.RawQuery = a.token
+	} else {
+		req.URL.RawQuery += "&" + a.token
+	}
+
+	return nil
+}
+
+// sharedKeyAuthorizer signs requests using the Azure Storage Shared Key
+// scheme (see "Authorize with Shared Key" in the Azure Storage REST
+// reference).
+type sharedKeyAuthorizer struct {
+	account string
+	key     []byte
+}
+
+func (a *sharedKeyAuthorizer) authorize(req *http.Request) error {
+	req.Header.Set("x-ms-date", nowRFC1123())
+
+	stringToSign := a.canonicalize(req)
+
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %v:%v", a.account, signature))
+
+	return nil
+}
+
+func (a *sharedKeyAuthorizer) canonicalize(req *http.Request) string {
+	contentLength := req.Header.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+
+	parts := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date - always sent as x-ms-date instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		a.canonicalizedHeaders(req),
+		a.canonicalizedResource(req),
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+func (a *sharedKeyAuthorizer) canonicalizedHeaders(req *http.Request) string {
+	var keys []string
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-ms-") {
+			keys = append(keys, lk)
+		}
+	}
+
+	sort.Strings(keys)
+
+	var lines []string
+	for _, k := range keys {
+		lines = append(lines, k+":"+req.Header.Get(k))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (a *sharedKeyAuthorizer) canonicalizedResource(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString("/")
+	b.WriteString(a.account)
+	b.WriteString(req.URL.Path)
+
+	q := req.URL.Query()
+
+	var keys []string
+	for k := range q {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		values := q[k]
+		sort.Strings(values)
+		b.WriteString("\n")
+		b.WriteString(strings.ToLower(k))
+		b.WriteString(":")
+		b.WriteString(strings.Join(values, ","))
+	}
+
+	return b.String()
+}
+
+func nowRFC1123() string {
+	return time.Now().UTC().Format(http.TimeFormat)
+}
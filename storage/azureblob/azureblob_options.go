@@ -0,0 +1,55 @@
+package azureblob
+
+// Options defines options for Azure Blob Storage-backed storage.
+type Options struct {
+	// Container is the name of the blob container objects are stored in.
+	Container string `json:"container"`
+
+	// StorageAccount is the Azure storage account name.
+	StorageAccount string `json:"storageAccount"`
+
+	// StorageDomain is the blob service DNS suffix, defaults to
+	// "blob.core.windows.net" when empty (overridable for sovereign clouds
+	// and the Azurite emulator).
+	StorageDomain string `json:"storageDomain,omitempty"`
+
+	// StorageKey authenticates using the account's shared key. Mutually
+	// exclusive with SASToken.
+	StorageKey string `json:"storageKey,omitempty"`
+
+	// SASToken authenticates using a pre-signed shared access signature
+	// query string (without the leading "?"). Mutually exclusive with
+	// StorageKey.
+	SASToken string `json:"sasToken,omitempty"`
+
+	// UseWorkloadIdentity authenticates using Azure AD workload identity /
+	// managed identity instead of an account key or SAS token. Not yet
+	// implemented: New rejects it so the option isn't silently accepted
+	// until the token exchange lands.
+	UseWorkloadIdentity bool `json:"useWorkloadIdentity,omitempty"`
+
+	// Prefix is prepended to all blob names, without a leading slash.
+	Prefix string `json:"prefix,omitempty"`
+
+	// DirectoryShards controls how blockIDs are split into pseudo-directory
+	// prefixes, with the same default ([3, 3]) as storage/webdav. Blob names
+	// are not the same as storage/webdav file names: every blockID at least
+	// as long as a shard is sharded (storage/webdav leaves blockIDs shorter
+	// than 20 characters unsharded), and no ".f" suffix is added, so a
+	// repository cannot be moved between the two backends as is.
+	DirectoryShards []int `json:"shards,omitempty"`
+
+	// MaxSingleRequestSize is the largest blob kopia will upload with a
+	// single "Put Blob" call. Larger blocks are uploaded as a set of blocks
+	// via "Put Block" and committed with "Put Block List". Defaults to 64MB
+	// when zero.
+	MaxSingleRequestSize int64 `json:"maxSingleRequestSize,omitempty"`
+
+	// BlockSize is the size of each block uploaded via "Put Block" when a
+	// blob exceeds MaxSingleRequestSize. Defaults to 16MB when zero.
+	BlockSize int64 `json:"blockSize,omitempty"`
+
+	// MaxConcurrentBlockUploads caps how many "Put Block" calls for a single
+	// blob are in flight at once. Defaults to 4 when zero.
+	MaxConcurrentBlockUploads int `json:"maxConcurrentBlockUploads,omitempty"`
+}
@@ -0,0 +1,532 @@
+// Package azureblob implements Storage based on Azure Blob Storage.
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kopia/kopia/storage"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	azureBlobStorageType = "azureblob"
+
+	defaultMaxSingleRequestSize      = 64 << 20 // 64MB
+	defaultBlockSize                 = 16 << 20 // 16MB
+	defaultMaxConcurrentBlockUploads = 4
+
+	apiVersion = "2020-10-02"
+)
+
+var defaultShards = []int{3, 3}
+
+// azStorage implements storage.Storage on top of an Azure Blob Storage
+// container.
+type azStorage struct {
+	Options
+
+	auth   authorizer
+	client *http.Client
+}
+
+func (s *azStorage) shards() []int {
+	if len(s.DirectoryShards) == 0 {
+		return defaultShards
+	}
+
+	return s.DirectoryShards
+}
+
+func (s *azStorage) blobName(blockID string) string {
+	shardPath := ""
+	for _, size := range s.shards() {
+		if len(blockID) < size {
+			break
+		}
+
+		shardPath += blockID[0:size] + "/"
+		blockID = blockID[size:]
+	}
+
+	name := shardPath + blockID
+	if s.Prefix != "" {
+		name = strings.TrimSuffix(s.Prefix, "/") + "/" + name
+	}
+
+	return name
+}
+
+func (s *azStorage) domain() string {
+	if s.StorageDomain != "" {
+		return s.StorageDomain
+	}
+
+	return "blob.core.windows.net"
+}
+
+func (s *azStorage) containerURL() string {
+	return fmt.Sprintf("https://%v.%v/%v", s.StorageAccount, s.domain(), url.PathEscape(s.Container))
+}
+
+func (s *azStorage) blobURL(blobName string) string {
+	var escaped []string
+	for _, part := range strings.Split(blobName, "/") {
+		escaped = append(escaped, url.PathEscape(part))
+	}
+
+	return s.containerURL() + "/" + strings.Join(escaped, "/")
+}
+
+func (s *azStorage) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("x-ms-version", apiVersion)
+
+	if err := s.auth.authorize(req); err != nil {
+		return nil, err
+	}
+
+	return s.client.Do(req)
+}
+
+func (s *azStorage) GetBlock(ctx context.Context, blockID string, offset, length int64) ([]byte, error) {
+	blobName := s.blobName(blockID)
+
+	req, err := http.NewRequest(http.MethodGet, s.blobURL(blobName), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+
+	if length > 0 {
+		req.Header.Set("x-ms-range", fmt.Sprintf("bytes=%v-%v", offset, offset+length-1))
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, storage.ErrBlockNotFound
+	case http.StatusOK, http.StatusPartialContent:
+		return ioutil.ReadAll(resp.Body)
+	default:
+		return nil, fmt.Errorf("unsupported response code %v during GET of %q", resp.StatusCode, blobName)
+	}
+}
+
+// PutBlock uploads data referenced by r under blockID. Small blocks are sent
+// as a single "Put Blob" request; blocks larger than MaxSingleRequestSize are
+// split into BlockSize chunks uploaded concurrently via "Put Block" and
+// finalized with "Put Block List", so the whole object never has to be
+// buffered in memory at once the way davStorage.PutBlock buffers into a
+// bytes.Buffer.
+func (s *azStorage) PutBlock(ctx context.Context, blockID string, r io.Reader) error {
+	blobName := s.blobName(blockID)
+
+	threshold := s.MaxSingleRequestSize
+	if threshold <= 0 {
+		threshold = defaultMaxSingleRequestSize
+	}
+
+	blockSize := s.BlockSize
+	if blockSize <= 0 {
+		blockSize = defaultBlockSize
+	}
+
+	// peek at threshold+1 bytes to decide which upload path to take without
+	// requiring the whole object in memory: if there's no more data after
+	// the peek, it was small enough for a single "Put Blob"; otherwise fall
+	// through to the chunked, concurrent block-blob path below.
+	peek := make([]byte, threshold+1)
+
+	n, err := io.ReadFull(r, peek)
+	switch err {
+	case io.ErrUnexpectedEOF, io.EOF:
+		return s.putSingleBlob(ctx, blobName, peek[:n])
+
+	case nil:
+		return s.putBlockBlob(ctx, blobName, io.MultiReader(bytes.NewReader(peek[:n]), r), blockSize)
+
+	default:
+		return err
+	}
+}
+
+func (s *azStorage) putSingleBlob(ctx context.Context, blobName string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, s.blobURL(blobName), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(data))
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	return checkWriteStatus(resp, blobName)
+}
+
+// putBlockBlob uploads r in blockSize chunks via concurrent "Put Block"
+// calls and commits the blob with "Put Block List".
+func (s *azStorage) putBlockBlob(ctx context.Context, blobName string, r io.Reader, blockSize int64) error {
+	maxConcurrency := s.MaxConcurrentBlockUploads
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMaxConcurrentBlockUploads
+	}
+
+	var (
+		blockIDs []string
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, maxConcurrency)
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	setErr := func(err error) {
+		errOnce.Do(func() { firstErr = err })
+	}
+
+	for i := 0; ; i++ {
+		buf := make([]byte, blockSize)
+
+		n, err := io.ReadFull(r, buf)
+		if n == 0 && (err == io.EOF) {
+			break
+		}
+
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", i)))
+		blockIDs = append(blockIDs, blockID)
+
+		chunk := buf[:n]
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(blockID string, chunk []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if uerr := s.putBlock(ctx, blobName, blockID, chunk); uerr != nil {
+				setErr(uerr)
+			}
+		}(blockID, chunk)
+
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			break
+		}
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return s.putBlockList(ctx, blobName, blockIDs)
+}
+
+func (s *azStorage) putBlock(ctx context.Context, blobName, blockID string, data []byte) error {
+	u := s.blobURL(blobName) + "?comp=block&blockid=" + url.QueryEscape(blockID)
+
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(data))
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	return checkWriteStatus(resp, blobName)
+}
+
+type blockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+func (s *azStorage) putBlockList(ctx context.Context, blobName string, blockIDs []string) error {
+	list := blockList{Latest: blockIDs}
+
+	body, err := xml.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	u := s.blobURL(blobName) + "?comp=blocklist"
+
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/xml")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	return checkWriteStatus(resp, blobName)
+}
+
+func checkWriteStatus(resp *http.Response, blobName string) error {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusNotFound:
+		return storage.ErrBlockNotFound
+	default:
+		return fmt.Errorf("unexpected response %v while writing %q", resp.StatusCode, blobName)
+	}
+}
+
+func (s *azStorage) DeleteBlock(ctx context.Context, blockID string) error {
+	blobName := s.blobName(blockID)
+
+	req, err := http.NewRequest(http.MethodDelete, s.blobURL(blobName), nil)
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("unexpected response %v while deleting %q", resp.StatusCode, blobName)
+	}
+}
+
+type listBlobsResult struct {
+	XMLName    xml.Name `xml:"EnumerationResults"`
+	NextMarker string   `xml:"NextMarker"`
+	Blobs      struct {
+		BlobPrefix []struct {
+			Name string `xml:"Name"`
+		} `xml:"BlobPrefix"`
+		Blob []struct {
+			Name       string `xml:"Name"`
+			Properties struct {
+				ContentLength int64  `xml:"Content-Length"`
+				LastModified  string `xml:"Last-Modified"`
+			} `xml:"Properties"`
+		} `xml:"Blob"`
+	} `xml:"Blobs"`
+}
+
+// ListBlocks lists blobs whose blockID starts with prefix by walking the
+// container's sharded pseudo-directory layout one shard at a time via the
+// "List Blobs" API's delimiter=/ mode, the same way davStorage.ListBlocks
+// does, rather than handing prefix to Azure directly: the blob name has
+// shard separators ("/") spliced in at fixed offsets, so a prefix that
+// doesn't land on a shard boundary (e.g. a 4-char prefix against 3-char
+// shards) would never match anything if compared against the raw name.
+func (s *azStorage) ListBlocks(ctx context.Context, prefix string) <-chan storage.BlockMetadata {
+	result := make(chan storage.BlockMetadata)
+
+	go func() {
+		defer close(result)
+
+		blobPrefix := s.Prefix
+		if blobPrefix != "" {
+			blobPrefix = strings.TrimSuffix(blobPrefix, "/") + "/"
+		}
+
+		s.walkContainer(ctx, blobPrefix, "", prefix, result)
+	}()
+
+	return result
+}
+
+// walkContainer lists dirPrefix with delimiter=/, descending into each
+// shard subdirectory (reported as a BlobPrefix) whose accumulated blockID
+// prefix is still compatible with queryPrefix, and emitting every blob
+// under a compatible path whose full blockID matches queryPrefix.
+func (s *azStorage) walkContainer(ctx context.Context, dirPrefix, blockIDPrefix, queryPrefix string, result chan<- storage.BlockMetadata) {
+	marker := ""
+
+	for {
+		page, err := s.listPage(ctx, dirPrefix, marker)
+		if err != nil {
+			log.Warn().Err(err).Msg("error listing azure blob container")
+			return
+		}
+
+		for _, sub := range page.Blobs.BlobPrefix {
+			name := strings.TrimSuffix(strings.TrimPrefix(sub.Name, dirPrefix), "/")
+			childBlockIDPrefix := blockIDPrefix + name
+
+			if compatiblePrefix(queryPrefix, childBlockIDPrefix) {
+				s.walkContainer(ctx, sub.Name, childBlockIDPrefix, queryPrefix, result)
+			}
+		}
+
+		for _, b := range page.Blobs.Blob {
+			blockID := blockIDPrefix + strings.TrimPrefix(b.Name, dirPrefix)
+			if !strings.HasPrefix(blockID, queryPrefix) {
+				continue
+			}
+
+			modTime, _ := time.Parse(http.TimeFormat, b.Properties.LastModified)
+
+			select {
+			case <-ctx.Done():
+				return
+			case result <- storage.BlockMetadata{
+				BlockID:   blockID,
+				Length:    uint64(b.Properties.ContentLength),
+				TimeStamp: modTime,
+			}:
+			}
+		}
+
+		if page.NextMarker == "" {
+			return
+		}
+
+		marker = page.NextMarker
+	}
+}
+
+// compatiblePrefix reports whether a shard path whose accumulated blockID
+// prefix is candidate could still contain a block matching queryPrefix, in
+// either direction: candidate may already be longer than queryPrefix (must
+// start with it), or queryPrefix may extend past what's been shard-resolved
+// so far (candidate must be a prefix of it).
+func compatiblePrefix(queryPrefix, candidate string) bool {
+	if len(queryPrefix) > len(candidate) {
+		return strings.HasPrefix(queryPrefix, candidate)
+	}
+
+	return strings.HasPrefix(candidate, queryPrefix)
+}
+
+func (s *azStorage) listPage(ctx context.Context, prefix, marker string) (*listBlobsResult, error) {
+	q := url.Values{}
+	q.Set("restype", "container")
+	q.Set("comp", "list")
+	q.Set("prefix", prefix)
+	q.Set("delimiter", "/")
+
+	if marker != "" {
+		q.Set("marker", marker)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.containerURL()+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response %v while listing container", resp.StatusCode)
+	}
+
+	var parsed listBlobsResult
+	if err := xml.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+
+	return &parsed, nil
+}
+
+func (s *azStorage) ConnectionInfo() storage.ConnectionInfo {
+	return storage.ConnectionInfo{
+		Type:   azureBlobStorageType,
+		Config: &s.Options,
+	}
+}
+
+func (s *azStorage) Close(ctx context.Context) error {
+	return nil
+}
+
+// New creates new Azure Blob Storage-backed storage in the specified
+// container.
+func New(ctx context.Context, opts *Options) (storage.Storage, error) {
+	if opts.Container == "" {
+		return nil, fmt.Errorf("container name must be specified")
+	}
+
+	if opts.StorageAccount == "" {
+		return nil, fmt.Errorf("storage account must be specified")
+	}
+
+	if opts.UseWorkloadIdentity {
+		return nil, fmt.Errorf("workload identity authentication is not yet implemented")
+	}
+
+	auth, err := newAuthorizer(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &azStorage{
+		Options: *opts,
+		auth:    auth,
+		client:  &http.Client{},
+	}
+
+	for _, sh := range r.shards() {
+		if sh == 0 {
+			return nil, fmt.Errorf("invalid shard spec: %v", opts.DirectoryShards)
+		}
+	}
+
+	return r, nil
+}
+
+func init() {
+	storage.AddSupportedStorage(
+		azureBlobStorageType,
+		func() interface{} { return &Options{} },
+		func(ctx context.Context, o interface{}) (storage.Storage, error) {
+			return New(ctx, o.(*Options))
+		})
+}
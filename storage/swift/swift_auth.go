@@ -0,0 +1,367 @@
+package swift
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authToken holds the result of a successful Keystone authentication.
+type authToken struct {
+	token      string
+	storageURL string
+	expiresAt  time.Time
+}
+
+// tokenManager caches the current Swift auth token and transparently
+// refreshes it once it has expired or the server rejects it with 401.
+type tokenManager struct {
+	mutex sync.Mutex
+	opts  *Options
+	http  *http.Client
+	cur   *authToken
+}
+
+func newTokenManager(opts *Options, httpClient *http.Client) *tokenManager {
+	return &tokenManager{opts: opts, http: httpClient}
+}
+
+// get returns a cached token, authenticating if necessary.
+func (t *tokenManager) get(ctx context.Context) (*authToken, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.cur != nil && time.Now().Before(t.cur.expiresAt) {
+		return t.cur, nil
+	}
+
+	tok, err := t.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.cur = tok
+	return tok, nil
+}
+
+// invalidate forces the next get() to re-authenticate, used after a 401.
+func (t *tokenManager) invalidate() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.cur = nil
+}
+
+func (t *tokenManager) authenticate(ctx context.Context) (*authToken, error) {
+	version := t.opts.AuthVersion
+	if version == "" {
+		version = detectAuthVersion(t.opts.AuthURL)
+	}
+
+	switch version {
+	case "3":
+		return t.authenticateV3(ctx)
+	case "2":
+		return t.authenticateV2(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported Keystone auth version %q", version)
+	}
+}
+
+func detectAuthVersion(authURL string) string {
+	if strings.Contains(authURL, "/v3") {
+		return "3"
+	}
+
+	return "2"
+}
+
+// --- Keystone v2 -----------------------------------------------------------
+
+type keystoneV2Request struct {
+	Auth struct {
+		TenantName          string `json:"tenantName,omitempty"`
+		TenantID            string `json:"tenantId,omitempty"`
+		PasswordCredentials struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"passwordCredentials"`
+	} `json:"auth"`
+}
+
+type keystoneV2Response struct {
+	Access struct {
+		Token struct {
+			ID      string    `json:"id"`
+			Expires time.Time `json:"expires"`
+		} `json:"token"`
+		ServiceCatalog []struct {
+			Type      string `json:"type"`
+			Endpoints []struct {
+				Region      string `json:"region"`
+				PublicURL   string `json:"publicURL"`
+				InternalURL string `json:"internalURL"`
+			} `json:"endpoints"`
+		} `json:"serviceCatalog"`
+	} `json:"access"`
+}
+
+func (t *tokenManager) authenticateV2(ctx context.Context) (*authToken, error) {
+	var reqBody keystoneV2Request
+	reqBody.Auth.TenantName = t.opts.Tenant
+	reqBody.Auth.TenantID = t.opts.TenantID
+	reqBody.Auth.PasswordCredentials.Username = t.opts.UserName
+	reqBody.Auth.PasswordCredentials.Password = t.opts.Password
+
+	var resp keystoneV2Response
+	if err := t.postJSON(ctx, strings.TrimSuffix(t.opts.AuthURL, "/")+"/tokens", reqBody, &resp); err != nil {
+		return nil, err
+	}
+
+	storageURL, err := pickV2Endpoint(resp.Access.ServiceCatalog, t.opts.Region, t.opts.UseInternalEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authToken{
+		token:      resp.Access.Token.ID,
+		storageURL: storageURL,
+		expiresAt:  resp.Access.Token.Expires,
+	}, nil
+}
+
+func pickV2Endpoint(catalog []struct {
+	Type      string `json:"type"`
+	Endpoints []struct {
+		Region      string `json:"region"`
+		PublicURL   string `json:"publicURL"`
+		InternalURL string `json:"internalURL"`
+	} `json:"endpoints"`
+}, region string, internal bool) (string, error) {
+	for _, entry := range catalog {
+		if entry.Type != "object-store" {
+			continue
+		}
+
+		for _, ep := range entry.Endpoints {
+			if region != "" && ep.Region != region {
+				continue
+			}
+
+			if internal && ep.InternalURL != "" {
+				return ep.InternalURL, nil
+			}
+
+			return ep.PublicURL, nil
+		}
+	}
+
+	return "", fmt.Errorf("object-store endpoint not found in service catalog for region %q", region)
+}
+
+// --- Keystone v3 -------------------------------------------------------------
+
+type keystoneV3Request struct {
+	Auth struct {
+		Identity struct {
+			Methods []string `json:"methods"`
+
+			Password *struct {
+				User struct {
+					Name     string `json:"name,omitempty"`
+					Password string `json:"password"`
+					Domain   *struct {
+						Name string `json:"name,omitempty"`
+						ID   string `json:"id,omitempty"`
+					} `json:"domain,omitempty"`
+				} `json:"user"`
+			} `json:"password,omitempty"`
+
+			ApplicationCredential *struct {
+				ID     string `json:"id"`
+				Secret string `json:"secret"`
+			} `json:"application_credential,omitempty"`
+		} `json:"identity"`
+
+		Scope *struct {
+			Project struct {
+				Name   string `json:"name,omitempty"`
+				ID     string `json:"id,omitempty"`
+				Domain *struct {
+					Name string `json:"name,omitempty"`
+					ID   string `json:"id,omitempty"`
+				} `json:"domain,omitempty"`
+			} `json:"project"`
+		} `json:"scope,omitempty"`
+	} `json:"auth"`
+}
+
+type keystoneV3Response struct {
+	Token struct {
+		ExpiresAt time.Time `json:"expires_at"`
+		Catalog   []struct {
+			Type      string `json:"type"`
+			Endpoints []struct {
+				Region    string `json:"region"`
+				Interface string `json:"interface"`
+				URL       string `json:"url"`
+			} `json:"endpoints"`
+		} `json:"catalog"`
+	} `json:"token"`
+}
+
+func (t *tokenManager) authenticateV3(ctx context.Context) (*authToken, error) {
+	var reqBody keystoneV3Request
+
+	if t.opts.ApplicationCredentialID != "" {
+		reqBody.Auth.Identity.Methods = []string{"application_credential"}
+		reqBody.Auth.Identity.ApplicationCredential = &struct {
+			ID     string `json:"id"`
+			Secret string `json:"secret"`
+		}{ID: t.opts.ApplicationCredentialID, Secret: t.opts.ApplicationCredentialSecret}
+	} else {
+		reqBody.Auth.Identity.Methods = []string{"password"}
+		reqBody.Auth.Identity.Password = &struct {
+			User struct {
+				Name     string `json:"name,omitempty"`
+				Password string `json:"password"`
+				Domain   *struct {
+					Name string `json:"name,omitempty"`
+					ID   string `json:"id,omitempty"`
+				} `json:"domain,omitempty"`
+			} `json:"user"`
+		}{}
+		reqBody.Auth.Identity.Password.User.Name = t.opts.UserName
+		reqBody.Auth.Identity.Password.User.Password = t.opts.Password
+
+		if t.opts.Domain != "" || t.opts.DomainID != "" {
+			reqBody.Auth.Identity.Password.User.Domain = &struct {
+				Name string `json:"name,omitempty"`
+				ID   string `json:"id,omitempty"`
+			}{Name: t.opts.Domain, ID: t.opts.DomainID}
+		}
+
+		if t.opts.Tenant != "" || t.opts.TenantID != "" {
+			reqBody.Auth.Scope = &struct {
+				Project struct {
+					Name   string `json:"name,omitempty"`
+					ID     string `json:"id,omitempty"`
+					Domain *struct {
+						Name string `json:"name,omitempty"`
+						ID   string `json:"id,omitempty"`
+					} `json:"domain,omitempty"`
+				} `json:"project"`
+			}{}
+			reqBody.Auth.Scope.Project.Name = t.opts.Tenant
+			reqBody.Auth.Scope.Project.ID = t.opts.TenantID
+
+			if t.opts.ProjectDomain != "" || t.opts.ProjectDomainID != "" {
+				reqBody.Auth.Scope.Project.Domain = &struct {
+					Name string `json:"name,omitempty"`
+					ID   string `json:"id,omitempty"`
+				}{Name: t.opts.ProjectDomain, ID: t.opts.ProjectDomainID}
+			}
+		}
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(t.opts.AuthURL, "/")+"/auth/tokens", mustJSON(reqBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("keystone v3 authentication failed with status %v", resp.StatusCode)
+	}
+
+	var parsed keystoneV3Response
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+
+	storageURL, err := pickV3Endpoint(parsed.Token.Catalog, t.opts.Region, t.opts.UseInternalEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authToken{
+		token:      resp.Header.Get("X-Subject-Token"),
+		storageURL: storageURL,
+		expiresAt:  parsed.Token.ExpiresAt,
+	}, nil
+}
+
+func pickV3Endpoint(catalog []struct {
+	Type      string `json:"type"`
+	Endpoints []struct {
+		Region    string `json:"region"`
+		Interface string `json:"interface"`
+		URL       string `json:"url"`
+	} `json:"endpoints"`
+}, region string, internal bool) (string, error) {
+	wantInterface := "public"
+	if internal {
+		wantInterface = "internal"
+	}
+
+	for _, entry := range catalog {
+		if entry.Type != "object-store" {
+			continue
+		}
+
+		for _, ep := range entry.Endpoints {
+			if region != "" && ep.Region != region {
+				continue
+			}
+
+			if ep.Interface == wantInterface {
+				return ep.URL, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("object-store %v endpoint not found in service catalog for region %q", wantInterface, region)
+}
+
+func (t *tokenManager) postJSON(ctx context.Context, url string, body, out interface{}) error {
+	req, err := http.NewRequest("POST", url, mustJSON(body))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("keystone authentication failed with status %v", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func mustJSON(v interface{}) *bytes.Reader {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return bytes.NewReader(b)
+}
@@ -0,0 +1,61 @@
+package swift
+
+// Options defines options for Swift-backed storage.
+type Options struct {
+	// AuthURL is the Keystone identity endpoint, e.g. https://auth.example.com/v3.
+	AuthURL string `json:"authURL"`
+
+	// AuthVersion selects the Keystone API version ("2" or "3"). Auto-detected
+	// from AuthURL suffix when empty.
+	AuthVersion string `json:"authVersion,omitempty"`
+
+	// UserName/Password are used for password authentication (Keystone v2 and v3).
+	UserName string `json:"userName,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// ApplicationCredentialID/Secret authenticate using Keystone v3 application
+	// credentials instead of a username/password pair.
+	ApplicationCredentialID     string `json:"applicationCredentialID,omitempty"`
+	ApplicationCredentialSecret string `json:"applicationCredentialSecret,omitempty"`
+
+	// Tenant/TenantID select the Keystone v2 tenant, or with Keystone v3
+	// password authentication the project the token is scoped to.
+	Tenant   string `json:"tenant,omitempty"`
+	TenantID string `json:"tenantID,omitempty"`
+
+	// Domain/DomainID select the Keystone v3 user domain.
+	Domain   string `json:"domain,omitempty"`
+	DomainID string `json:"domainID,omitempty"`
+
+	// ProjectDomain/ProjectDomainID select the Keystone v3 project domain.
+	ProjectDomain   string `json:"projectDomain,omitempty"`
+	ProjectDomainID string `json:"projectDomainID,omitempty"`
+
+	// Region restricts which catalog entry is used when the service is
+	// available in more than one region.
+	Region string `json:"region,omitempty"`
+
+	// UseInternalEndpoint selects the "internal" catalog endpoint instead of
+	// the "public" one, which is normally cheaper/faster when kopia runs
+	// inside the same OpenStack deployment as the object store.
+	UseInternalEndpoint bool `json:"useInternalEndpoint,omitempty"`
+
+	// Container is the name of the Swift container objects are stored in.
+	Container string `json:"container"`
+
+	// Prefix is prepended to all object names, without a leading slash.
+	Prefix string `json:"prefix,omitempty"`
+
+	// DirectoryShards controls how blockIDs are split into pseudo-directory
+	// prefixes, with the same default ([3, 3]) as storage/webdav. Object
+	// names are not the same as storage/webdav file names: every blockID at
+	// least as long as a shard is sharded (storage/webdav leaves blockIDs
+	// shorter than 20 characters unsharded), and no ".f" suffix is added, so
+	// a repository cannot be moved between the two backends as is.
+	DirectoryShards []int `json:"shards,omitempty"`
+
+	// MaxSegmentSize is the largest object kopia will upload as a single PUT.
+	// Blocks larger than this are uploaded as Dynamic Large Object segments.
+	// Defaults to 256MB when zero.
+	MaxSegmentSize int64 `json:"maxSegmentSize,omitempty"`
+}
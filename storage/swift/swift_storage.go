@@ -0,0 +1,510 @@
+// Package swift implements Storage based on OpenStack Object Storage (Swift).
+package swift
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kopia/kopia/storage"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	swiftStorageType = "swift"
+
+	defaultMaxSegmentSize = 256 << 20 // 256MB
+
+	maxRetries     = 5
+	retryBaseDelay = 200 * time.Millisecond
+)
+
+var defaultShards = []int{3, 3}
+
+// swiftStorage implements storage.Storage on top of an OpenStack Object
+// Storage (Swift) container. Object names are sharded into pseudo-directories
+// with the same shard sizes as storage/webdav, but the names differ from
+// storage/webdav's (see Options.DirectoryShards), so a repository cannot be
+// moved between the two backends as is.
+type swiftStorage struct {
+	Options
+
+	tokens *tokenManager
+	client *http.Client
+}
+
+func (s *swiftStorage) shards() []int {
+	if len(s.DirectoryShards) == 0 {
+		return defaultShards
+	}
+
+	return s.DirectoryShards
+}
+
+func (s *swiftStorage) objectName(blockID string) string {
+	shardPath := ""
+	for _, size := range s.shards() {
+		if len(blockID) < size {
+			break
+		}
+
+		shardPath += blockID[0:size] + "/"
+		blockID = blockID[size:]
+	}
+
+	name := shardPath + blockID
+	if s.Prefix != "" {
+		name = strings.TrimSuffix(s.Prefix, "/") + "/" + name
+	}
+
+	return name
+}
+
+func (s *swiftStorage) containerURL(tok *authToken) string {
+	return strings.TrimSuffix(tok.storageURL, "/") + "/" + url.PathEscape(s.Container)
+}
+
+func (s *swiftStorage) objectURL(tok *authToken, objectName string) string {
+	// each path segment is escaped individually so that the "/" shard
+	// separators introduced by objectName() are preserved.
+	var escaped []string
+	for _, part := range strings.Split(objectName, "/") {
+		escaped = append(escaped, url.PathEscape(part))
+	}
+
+	return s.containerURL(tok) + "/" + strings.Join(escaped, "/")
+}
+
+// doWithRetry executes buildReq against a fresh auth token, retrying 5xx
+// responses with exponential backoff and transparently refreshing the token
+// once on a 401.
+func (s *swiftStorage) doWithRetry(ctx context.Context, buildReq func(tok *authToken) (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		tok, err := s.tokens.get(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to authenticate with Swift: %v", err)
+		}
+
+		req, err := buildReq(tok)
+		if err != nil {
+			return nil, err
+		}
+
+		req = req.WithContext(ctx)
+		req.Header.Set("X-Auth-Token", tok.token)
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			lastErr = err
+			backoff(attempt)
+			continue
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized:
+			resp.Body.Close() // nolint:errcheck
+			s.tokens.invalidate()
+			lastErr = fmt.Errorf("authentication expired")
+			continue
+
+		case resp.StatusCode >= 500:
+			resp.Body.Close() // nolint:errcheck
+			lastErr = fmt.Errorf("server error %v", resp.StatusCode)
+			backoff(attempt)
+			continue
+
+		default:
+			return resp, nil
+		}
+	}
+
+	return nil, fmt.Errorf("giving up after %v attempts: %v", maxRetries, lastErr)
+}
+
+func backoff(attempt int) {
+	time.Sleep(retryBaseDelay * time.Duration(1<<uint(attempt)))
+}
+
+func (s *swiftStorage) GetBlock(ctx context.Context, blockID string, offset, length int64) ([]byte, error) {
+	objectName := s.objectName(blockID)
+
+	resp, err := s.doWithRetry(ctx, func(tok *authToken) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, s.objectURL(tok, objectName), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if length > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", offset, offset+length-1))
+		}
+
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, storage.ErrBlockNotFound
+	case http.StatusOK, http.StatusPartialContent:
+		return ioutil.ReadAll(resp.Body)
+	default:
+		return nil, fmt.Errorf("unsupported response code %v during GET of %q", resp.StatusCode, objectName)
+	}
+}
+
+// PutBlock uploads data to a temporary object name and atomically publishes it
+// via a server-side COPY followed by a DELETE of the temporary object. Blocks
+// larger than MaxSegmentSize are instead uploaded as a Dynamic Large Object:
+// the data is split into segments under a hidden "segments" sub-path, and a
+// zero-length manifest object carrying X-Object-Manifest is PUT directly
+// under the target name (DLO manifests are published in place, so the
+// temp+COPY dance is only needed for the non-segmented path).
+func (s *swiftStorage) PutBlock(ctx context.Context, blockID string, r io.Reader) error {
+	objectName := s.objectName(blockID)
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	threshold := s.MaxSegmentSize
+	if threshold <= 0 {
+		threshold = defaultMaxSegmentSize
+	}
+
+	if int64(len(data)) > threshold {
+		return s.putLargeObject(ctx, objectName, data, threshold)
+	}
+
+	tmpName := objectName + "-tmp-" + makeNonce()
+	if err := s.putObject(ctx, tmpName, data); err != nil {
+		return err
+	}
+
+	if err := s.publish(ctx, tmpName, objectName); err != nil {
+		if delerr := s.deleteObject(ctx, tmpName); delerr != nil {
+			log.Warn().Err(delerr).Msg("unable to delete temporary swift object")
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *swiftStorage) putLargeObject(ctx context.Context, objectName string, data []byte, segmentSize int64) error {
+	segmentsBase := "segments/" + strings.TrimPrefix(objectName, s.Prefix)
+
+	var segmentIndex int
+
+	for remaining := data; len(remaining) > 0; segmentIndex++ {
+		n := segmentSize
+		if int64(len(remaining)) < n {
+			n = int64(len(remaining))
+		}
+
+		segmentName := fmt.Sprintf("%v/%08d", segmentsBase, segmentIndex)
+		if err := s.putObject(ctx, segmentName, remaining[:n]); err != nil {
+			return err
+		}
+
+		remaining = remaining[n:]
+	}
+
+	resp, err := s.doWithRetry(ctx, func(tok *authToken) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, s.objectURL(tok, objectName), http.NoBody)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("X-Object-Manifest", fmt.Sprintf("%v/%v", s.Container, segmentsBase))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	return checkWriteStatus(resp, objectName)
+}
+
+func (s *swiftStorage) putObject(ctx context.Context, objectName string, data []byte) error {
+	resp, err := s.doWithRetry(ctx, func(tok *authToken) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, s.objectURL(tok, objectName), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		req.ContentLength = int64(len(data))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	return checkWriteStatus(resp, objectName)
+}
+
+// publish makes tmpName visible as objectName using a server-side COPY
+// followed by a DELETE of the temporary object, so readers never observe a
+// partially-written target.
+func (s *swiftStorage) publish(ctx context.Context, tmpName, objectName string) error {
+	resp, err := s.doWithRetry(ctx, func(tok *authToken) (*http.Request, error) {
+		req, err := http.NewRequest("COPY", s.objectURL(tok, tmpName), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Destination", fmt.Sprintf("/%v/%v", s.Container, objectName))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if err := checkWriteStatus(resp, objectName); err != nil {
+		return err
+	}
+
+	return s.deleteObject(ctx, tmpName)
+}
+
+func checkWriteStatus(resp *http.Response, objectName string) error {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent, http.StatusAccepted:
+		return nil
+	case http.StatusNotFound:
+		return storage.ErrBlockNotFound
+	default:
+		return fmt.Errorf("unexpected response %v while writing %q", resp.StatusCode, objectName)
+	}
+}
+
+func (s *swiftStorage) DeleteBlock(ctx context.Context, blockID string) error {
+	return s.deleteObject(ctx, s.objectName(blockID))
+}
+
+func (s *swiftStorage) deleteObject(ctx context.Context, objectName string) error {
+	resp, err := s.doWithRetry(ctx, func(tok *authToken) (*http.Request, error) {
+		return http.NewRequest(http.MethodDelete, s.objectURL(tok, objectName), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("unexpected response %v while deleting %q", resp.StatusCode, objectName)
+	}
+}
+
+// swiftListEntry is one row of a Swift container listing taken with
+// delimiter=/: either a pseudo-"subdirectory" (only Subdir set, a shard
+// path ending in "/") or a real object.
+type swiftListEntry struct {
+	Subdir       string    `json:"subdir,omitempty"`
+	Name         string    `json:"name,omitempty"`
+	Bytes        uint64    `json:"bytes,omitempty"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+}
+
+// ListBlocks lists objects whose blockID starts with prefix by walking the
+// container's sharded pseudo-directory layout one shard at a time, the same
+// way davStorage.ListBlocks does, rather than handing prefix to Swift
+// directly: the object key has shard separators ("/") spliced in at fixed
+// offsets, so a prefix that doesn't land on a shard boundary (e.g. a 4-char
+// prefix against 3-char shards) would never match anything if compared
+// against the raw key.
+func (s *swiftStorage) ListBlocks(ctx context.Context, prefix string) <-chan storage.BlockMetadata {
+	result := make(chan storage.BlockMetadata)
+
+	go func() {
+		defer close(result)
+
+		objectPrefix := s.Prefix
+		if objectPrefix != "" {
+			objectPrefix = strings.TrimSuffix(objectPrefix, "/") + "/"
+		}
+
+		s.walkContainer(ctx, objectPrefix, "", prefix, result)
+	}()
+
+	return result
+}
+
+// walkContainer lists dirPrefix with Swift's delimiter=/ listing, descending
+// into each shard subdirectory whose accumulated blockID prefix is still
+// compatible with queryPrefix, and emitting every object under a compatible
+// path whose full blockID matches queryPrefix.
+func (s *swiftStorage) walkContainer(ctx context.Context, dirPrefix, blockIDPrefix, queryPrefix string, result chan<- storage.BlockMetadata) {
+	marker := ""
+
+	for {
+		entries, err := s.listPage(ctx, dirPrefix, marker)
+		if err != nil {
+			log.Warn().Err(err).Msg("error listing swift container")
+			return
+		}
+
+		if len(entries) == 0 {
+			return
+		}
+
+		for _, e := range entries {
+			if e.Subdir != "" {
+				marker = e.Subdir
+
+				// the Dynamic Large Object segments written by putLargeObject
+				// live under "segments/" and are not blocks.
+				if e.Subdir == "segments/" {
+					continue
+				}
+
+				name := strings.TrimSuffix(strings.TrimPrefix(e.Subdir, dirPrefix), "/")
+				childBlockIDPrefix := blockIDPrefix + name
+
+				if compatiblePrefix(queryPrefix, childBlockIDPrefix) {
+					s.walkContainer(ctx, e.Subdir, childBlockIDPrefix, queryPrefix, result)
+				}
+
+				continue
+			}
+
+			marker = e.Name
+
+			blockID := blockIDPrefix + strings.TrimPrefix(e.Name, dirPrefix)
+			if !strings.HasPrefix(blockID, queryPrefix) {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case result <- storage.BlockMetadata{
+				BlockID:   blockID,
+				Length:    e.Bytes,
+				TimeStamp: e.LastModified,
+			}:
+			}
+		}
+	}
+}
+
+// compatiblePrefix reports whether a shard path whose accumulated blockID
+// prefix is candidate could still contain a block matching queryPrefix, in
+// either direction: candidate may already be longer than queryPrefix (must
+// start with it), or queryPrefix may extend past what's been shard-resolved
+// so far (candidate must be a prefix of it).
+func compatiblePrefix(queryPrefix, candidate string) bool {
+	if len(queryPrefix) > len(candidate) {
+		return strings.HasPrefix(queryPrefix, candidate)
+	}
+
+	return strings.HasPrefix(candidate, queryPrefix)
+}
+
+// listPage fetches a single page of a delimiter=/ container listing rooted
+// at prefix, using Swift's JSON listing format (?format=json) paginated
+// with ?prefix=&marker=.
+func (s *swiftStorage) listPage(ctx context.Context, prefix, marker string) ([]swiftListEntry, error) {
+	resp, err := s.doWithRetry(ctx, func(tok *authToken) (*http.Request, error) {
+		q := url.Values{}
+		q.Set("format", "json")
+		q.Set("prefix", prefix)
+		q.Set("delimiter", "/")
+
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+
+		return http.NewRequest(http.MethodGet, s.containerURL(tok)+"?"+q.Encode(), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, nil
+	case http.StatusOK:
+		var entries []swiftListEntry
+		if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+			return nil, err
+		}
+
+		return entries, nil
+	default:
+		return nil, fmt.Errorf("unexpected response %v while listing container", resp.StatusCode)
+	}
+}
+
+func (s *swiftStorage) ConnectionInfo() storage.ConnectionInfo {
+	return storage.ConnectionInfo{
+		Type:   swiftStorageType,
+		Config: &s.Options,
+	}
+}
+
+func (s *swiftStorage) Close(ctx context.Context) error {
+	return nil
+}
+
+// New creates new Swift-backed storage for the specified container.
+func New(ctx context.Context, opts *Options) (storage.Storage, error) {
+	if opts.Container == "" {
+		return nil, fmt.Errorf("container name must be specified")
+	}
+
+	httpClient := &http.Client{}
+
+	r := &swiftStorage{
+		Options: *opts,
+		client:  httpClient,
+	}
+	r.tokens = newTokenManager(&r.Options, httpClient)
+
+	for _, sh := range r.shards() {
+		if sh == 0 {
+			return nil, fmt.Errorf("invalid shard spec: %v", opts.DirectoryShards)
+		}
+	}
+
+	// fail fast on bad credentials instead of surfacing the error on first use.
+	if _, err := r.tokens.get(ctx); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func makeNonce() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+func init() {
+	storage.AddSupportedStorage(
+		swiftStorageType,
+		func() interface{} { return &Options{} },
+		func(ctx context.Context, o interface{}) (storage.Storage, error) {
+			return New(ctx, o.(*Options))
+		})
+}
@@ -0,0 +1,419 @@
+package swift
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/storage"
+)
+
+// fakeSwiftContainer is an in-memory fake of enough of the Swift object and
+// Keystone APIs to exercise swiftStorage end to end without a real
+// OpenStack deployment.
+type fakeSwiftContainer struct {
+	mu sync.Mutex
+
+	objects     map[string][]byte
+	validTokens map[string]bool
+
+	// failuresRemaining, keyed by object name, makes the next N requests for
+	// that object fail with 500 before succeeding, to exercise doWithRetry's
+	// backoff path.
+	failuresRemaining map[string]int
+
+	authCount int
+}
+
+func entryKey(e swiftListEntry) string {
+	if e.Subdir != "" {
+		return e.Subdir
+	}
+
+	return e.Name
+}
+
+func newFakeSwiftServer(t *testing.T) (*httptest.Server, *fakeSwiftContainer) {
+	t.Helper()
+
+	fake := &fakeSwiftContainer{
+		objects:           map[string][]byte{},
+		validTokens:       map[string]bool{"fake-token": true},
+		failuresRemaining: map[string]int{},
+	}
+
+	var srv *httptest.Server
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v3/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		fake.authCount++
+		token := fmt.Sprintf("fake-token-%d", fake.authCount)
+		fake.validTokens[token] = true
+		fake.mu.Unlock()
+
+		type endpoint struct {
+			Region    string `json:"region"`
+			Interface string `json:"interface"`
+			URL       string `json:"url"`
+		}
+
+		type catalogEntry struct {
+			Type      string     `json:"type"`
+			Endpoints []endpoint `json:"endpoints"`
+		}
+
+		resp := struct {
+			Token struct {
+				ExpiresAt time.Time      `json:"expires_at"`
+				Catalog   []catalogEntry `json:"catalog"`
+			} `json:"token"`
+		}{}
+		resp.Token.ExpiresAt = time.Now().Add(time.Hour)
+		resp.Token.Catalog = []catalogEntry{{
+			Type:      "object-store",
+			Endpoints: []endpoint{{Region: "", Interface: "public", URL: srv.URL + "/v1/AUTH_test"}},
+		}}
+
+		w.Header().Set("X-Subject-Token", token)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+
+	mux.HandleFunc("/v1/AUTH_test/testcontainer", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		if !fake.validTokens[r.Header.Get("X-Auth-Token")] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		prefix := r.URL.Query().Get("prefix")
+		marker := r.URL.Query().Get("marker")
+		delimiter := r.URL.Query().Get("delimiter")
+
+		var names []string
+		for name := range fake.objects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		seenSubdirs := map[string]bool{}
+
+		var entries []swiftListEntry
+
+		for _, name := range names {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+
+			rest := strings.TrimPrefix(name, prefix)
+
+			if delimiter != "" {
+				if idx := strings.Index(rest, delimiter); idx >= 0 {
+					subdir := prefix + rest[:idx+len(delimiter)]
+					if !seenSubdirs[subdir] {
+						seenSubdirs[subdir] = true
+						entries = append(entries, swiftListEntry{Subdir: subdir})
+					}
+
+					continue
+				}
+			}
+
+			entries = append(entries, swiftListEntry{
+				Name:  name,
+				Bytes: uint64(len(fake.objects[name])),
+			})
+		}
+
+		sort.Slice(entries, func(i, j int) bool { return entryKey(entries[i]) < entryKey(entries[j]) })
+
+		var page []swiftListEntry
+
+		for _, e := range entries {
+			if entryKey(e) > marker {
+				page = append(page, e)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(page)
+	})
+
+	mux.HandleFunc("/v1/AUTH_test/testcontainer/", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		if !fake.validTokens[r.Header.Get("X-Auth-Token")] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		objectName := strings.TrimPrefix(r.URL.Path, "/v1/AUTH_test/testcontainer/")
+
+		if fake.failuresRemaining[objectName] > 0 {
+			fake.failuresRemaining[objectName]--
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(r.Body)
+			fake.objects[objectName] = data
+
+			if r.Header.Get("X-Object-Manifest") != "" {
+				w.Header().Set("X-Object-Manifest-Accepted", r.Header.Get("X-Object-Manifest"))
+			}
+
+			w.WriteHeader(http.StatusCreated)
+
+		case http.MethodGet:
+			data, ok := fake.objects[objectName]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			_, _ = w.Write(data)
+
+		case "COPY":
+			dest := strings.TrimPrefix(r.Header.Get("Destination"), "/testcontainer/")
+
+			data, ok := fake.objects[objectName]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			fake.objects[dest] = data
+			w.WriteHeader(http.StatusCreated)
+
+		case http.MethodDelete:
+			delete(fake.objects, objectName)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	srv = httptest.NewServer(mux)
+
+	return srv, fake
+}
+
+// newTestStorage wires up a swiftStorage against the fake server with a
+// pre-seeded token, bypassing the real Keystone handshake the same way
+// tests for other HTTP-based backends stub out auth.
+func newTestStorage(t *testing.T) storage.Storage {
+	t.Helper()
+
+	s, _ := newTestStorageAndFake(t)
+	return s
+}
+
+func newTestStorageAndFake(t *testing.T) (*swiftStorage, *fakeSwiftContainer) {
+	t.Helper()
+
+	srv, fake := newFakeSwiftServer(t)
+	t.Cleanup(srv.Close)
+
+	opts := &Options{
+		AuthURL:   srv.URL + "/v3",
+		UserName:  "tester",
+		Password:  "secret",
+		Tenant:    "test",
+		Container: "testcontainer",
+	}
+
+	httpClient := &http.Client{}
+
+	r := &swiftStorage{Options: *opts, client: httpClient}
+	r.tokens = newTokenManager(&r.Options, httpClient)
+	r.tokens.cur = &authToken{
+		token:      "fake-token",
+		storageURL: srv.URL + "/v1/AUTH_test",
+		expiresAt:  time.Now().Add(time.Hour),
+	}
+
+	return r, fake
+}
+
+func TestSwiftStoragePutGetDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	if err := s.PutBlock(ctx, "abcdefgh0123456789", strings.NewReader("hello world")); err != nil {
+		t.Fatalf("PutBlock failed: %v", err)
+	}
+
+	data, err := s.GetBlock(ctx, "abcdefgh0123456789", 0, 0)
+	if err != nil {
+		t.Fatalf("GetBlock failed: %v", err)
+	}
+
+	if string(data) != "hello world" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+
+	if err := s.DeleteBlock(ctx, "abcdefgh0123456789"); err != nil {
+		t.Fatalf("DeleteBlock failed: %v", err)
+	}
+
+	if _, err := s.GetBlock(ctx, "abcdefgh0123456789", 0, 0); err != storage.ErrBlockNotFound {
+		t.Fatalf("expected ErrBlockNotFound, got %v", err)
+	}
+}
+
+func TestSwiftStorageListBlocks(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	for _, id := range []string{"aaa111aaa111aaa1111", "aaa222aaa222aaa2222", "bbb333bbb333bbb3333"} {
+		if err := s.PutBlock(ctx, id, strings.NewReader(id)); err != nil {
+			t.Fatalf("PutBlock(%v) failed: %v", id, err)
+		}
+	}
+
+	var found []string
+	for bm := range s.ListBlocks(ctx, "aaa") {
+		found = append(found, bm.BlockID)
+	}
+
+	if len(found) != 2 {
+		t.Fatalf("expected 2 blocks with prefix aaa, got %v", found)
+	}
+}
+
+// TestSwiftStorageListBlocksBoundaryCrossingPrefix exercises a prefix that
+// does not land on a shard boundary (DirectoryShards default is [3, 3], so
+// "aaa1" straddles the first shard). A flat string-prefix match against the
+// sharded object key ("aaa/111/...") would miss this block even though its
+// blockID genuinely starts with "aaa1".
+func TestSwiftStorageListBlocksBoundaryCrossingPrefix(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	for _, id := range []string{"aaa111aaa111aaa1111", "aaa211aaa211aaa2111", "aaa900aaa900aaa9001"} {
+		if err := s.PutBlock(ctx, id, strings.NewReader(id)); err != nil {
+			t.Fatalf("PutBlock(%v) failed: %v", id, err)
+		}
+	}
+
+	var found []string
+	for bm := range s.ListBlocks(ctx, "aaa1") {
+		found = append(found, bm.BlockID)
+	}
+
+	if len(found) != 1 || found[0] != "aaa111aaa111aaa1111" {
+		t.Fatalf("expected exactly [aaa111aaa111aaa1111] for boundary-crossing prefix \"aaa1\", got %v", found)
+	}
+}
+
+func TestSwiftStorageRetriesOn5xx(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestStorageAndFake(t)
+
+	objectName := s.objectName("retryblock0123456789")
+
+	// PutBlock's first write goes to a randomized temp name, so induce the
+	// failures against a name we write directly instead.
+	fake.mu.Lock()
+	fake.failuresRemaining[objectName] = 2
+	fake.mu.Unlock()
+
+	if err := s.putObject(ctx, objectName, []byte("retry me")); err != nil {
+		t.Fatalf("putObject failed after induced 5xx failures: %v", err)
+	}
+
+	data, err := s.GetBlock(ctx, "retryblock0123456789", 0, 0)
+	if err != nil {
+		t.Fatalf("GetBlock failed: %v", err)
+	}
+
+	if string(data) != "retry me" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+}
+
+func TestSwiftStorageReauthenticatesOn401(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestStorageAndFake(t)
+
+	// Poison the cached token so the first request gets a 401; the handler
+	// should invalidate it, re-authenticate against the fake Keystone
+	// endpoint, and retry with the freshly issued token.
+	s.tokens.cur.token = "stale-token"
+
+	if err := s.PutBlock(ctx, "reauthblock0123456789", strings.NewReader("hi")); err != nil {
+		t.Fatalf("PutBlock failed: %v", err)
+	}
+
+	fake.mu.Lock()
+	authCount := fake.authCount
+	fake.mu.Unlock()
+
+	if authCount != 1 {
+		t.Fatalf("expected exactly 1 re-authentication, got %v", authCount)
+	}
+
+	if s.tokens.cur.token == "stale-token" {
+		t.Fatal("expected token to be refreshed")
+	}
+}
+
+func TestSwiftStoragePutLargeObjectUsesDynamicLargeObject(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestStorageAndFake(t)
+
+	s.MaxSegmentSize = 4
+
+	data := "0123456789" // 3 segments of size 4, 4, 2
+
+	if err := s.PutBlock(ctx, "largeobjectblock01234", strings.NewReader(data)); err != nil {
+		t.Fatalf("PutBlock failed: %v", err)
+	}
+
+	got, err := s.GetBlock(ctx, "largeobjectblock01234", 0, 0)
+	if err != nil {
+		t.Fatalf("GetBlock failed: %v", err)
+	}
+
+	if string(got) != "" {
+		// The DLO manifest object itself is zero-length; GetBlock on a real
+		// Swift cluster would transparently stream the concatenated
+		// segments, which this fake doesn't emulate, so we instead assert
+		// the segments were written individually.
+		t.Fatalf("expected manifest object to read back empty against the fake server, got %q", got)
+	}
+
+	manifestObjectName := s.objectName("largeobjectblock01234")
+	segmentsBase := "segments/" + strings.TrimPrefix(manifestObjectName, s.Prefix)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	for i, want := range []string{"0123", "4567", "89"} {
+		segmentName := fmt.Sprintf("%v/%08d", segmentsBase, i)
+
+		got, ok := fake.objects[segmentName]
+		if !ok {
+			t.Fatalf("segment %v not found", segmentName)
+		}
+
+		if string(got) != want {
+			t.Fatalf("segment %v: expected %q, got %q", segmentName, want, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package webdav
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// lockTimeout is the duration requested in the RFC 4918 Timeout header; the
+// lock is only ever held for the few round-trips needed to publish or delete
+// a single block, so a short, fixed timeout is sufficient.
+const lockTimeout = "Second-30"
+
+const lockRequestBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+  <D:lockscope><D:exclusive/></D:lockscope>
+  <D:locktype><D:write/></D:locktype>
+</D:lockinfo>`
+
+// davLock is the result of attempting to acquire a write lock. held is false
+// when locking is disabled (Options.UseLocking) or the server doesn't
+// implement LOCK, in which case token is empty and callers should proceed
+// without one.
+type davLock struct {
+	token string
+	held  bool
+}
+
+// lockForWrite requests an exclusive write lock on urlStr, used to guard the
+// MOVE/DELETE/PUT that publish or remove a block against a racing writer.
+// Servers that respond to LOCK with 501 (Not Implemented) or 405 (Method Not
+// Allowed) are treated as not supporting locking at all, and the lockless
+// path is used instead.
+func (d *davStorage) lockForWrite(urlStr string) (davLock, error) {
+	if !d.Options.UseLocking {
+		return davLock{}, nil
+	}
+
+	req, err := http.NewRequest("LOCK", urlStr, strings.NewReader(lockRequestBody))
+	if err != nil {
+		return davLock{}, err
+	}
+
+	req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Timeout", lockTimeout)
+
+	resp, err := d.executeRequest(req, nil)
+	if err != nil {
+		return davLock{}, err
+	}
+
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusNotImplemented, http.StatusMethodNotAllowed:
+		return davLock{}, nil
+
+	case http.StatusOK, http.StatusCreated:
+		token := strings.Trim(resp.Header.Get("Lock-Token"), "<>")
+		if token == "" {
+			return davLock{}, fmt.Errorf("LOCK response for %q did not include a Lock-Token", urlStr)
+		}
+
+		return davLock{token: token, held: true}, nil
+
+	default:
+		return davLock{}, fmt.Errorf("unhandled status code %v during LOCK %q", resp.StatusCode, urlStr)
+	}
+}
+
+func (d *davStorage) unlock(urlStr, lockToken string) error {
+	req, err := http.NewRequest("UNLOCK", urlStr, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Lock-Token", "<"+lockToken+">")
+
+	resp, err := d.executeRequest(req, nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	default:
+		return fmt.Errorf("unhandled status code %v during UNLOCK %q", resp.StatusCode, urlStr)
+	}
+}
@@ -0,0 +1,34 @@
+package webdav
+
+import "github.com/kopia/kopia/storage/webdav/webdavauth"
+
+// Options defines options for WebDAV-backed storage.
+type Options struct {
+	// URL is the base WebDAV collection URL blocks are stored under.
+	URL string `json:"url"`
+
+	// DirectoryShards controls how blockIDs are split into pseudo-directory
+	// prefixes, exactly like the local file storage, so that a repository can
+	// be moved between the two interchangeably.
+	DirectoryShards []int `json:"shards,omitempty"`
+
+	// Auth configures how requests are authenticated against the WebDAV
+	// server. When nil, requests are sent unauthenticated, same as before
+	// this field existed.
+	Auth *webdavauth.Options `json:"auth,omitempty"`
+
+	// UseLocking enables RFC 4918 LOCK/UNLOCK around block publication, so
+	// that two writers racing to publish the same blockID can't leave it in
+	// an ambiguous post-MOVE state. Off by default since many WebDAV servers
+	// don't implement locking; when the server responds to LOCK with 501 or
+	// 405, PutBlock and DeleteBlock silently fall back to the lockless path.
+	UseLocking bool `json:"useLocking,omitempty"`
+}
+
+func (d *davStorage) shards() []int {
+	if len(d.DirectoryShards) == 0 {
+		return fsDefaultShards
+	}
+
+	return d.DirectoryShards
+}
@@ -8,10 +8,10 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/kopia/kopia/storage"
+	"github.com/kopia/kopia/storage/webdav/webdavauth"
 	"github.com/rs/zerolog/log"
 )
 
@@ -176,12 +176,14 @@ func getParentURL(u string) string {
 	return ""
 }
 
-func (d *davStorage) delete(urlStr string) error {
+func (d *davStorage) delete(urlStr, lockToken string) error {
 	req, err := http.NewRequest("DELETE", urlStr, nil)
 	if err != nil {
 		return err
 	}
 
+	setIfHeader(req, lockToken)
+
 	resp, err := d.executeRequest(req, nil)
 	if err != nil {
 		return err
@@ -196,13 +198,14 @@ func (d *davStorage) delete(urlStr string) error {
 	}
 }
 
-func (d *davStorage) move(urlOld, urlNew string) error {
+func (d *davStorage) move(urlOld, urlNew, lockToken string) error {
 	req, err := http.NewRequest("MOVE", urlOld, nil)
 	if err != nil {
 		return err
 	}
 	req.Header.Add("Destination", urlNew)
 	req.Header.Add("Overwrite", "T")
+	setTaggedIfHeader(req, urlNew, lockToken)
 
 	resp, err := d.executeRequest(req, nil)
 	if err != nil {
@@ -219,12 +222,14 @@ func (d *davStorage) move(urlOld, urlNew string) error {
 	}
 }
 
-func (d *davStorage) putBlockInternal(urlStr string, data []byte) error {
+func (d *davStorage) putBlockInternal(urlStr string, data []byte, lockToken string) error {
 	req, err := http.NewRequest("PUT", urlStr, nil)
 	if err != nil {
 		return err
 	}
 
+	setIfHeader(req, lockToken)
+
 	resp, err := d.executeRequest(req, data)
 	if err != nil {
 		return err
@@ -243,6 +248,38 @@ func (d *davStorage) putBlockInternal(urlStr string, data []byte) error {
 	}
 }
 
+// setIfHeader adds an RFC 4918 untagged "If" header asserting the given
+// lock token against the Request-URI, so that a locked write can only
+// succeed for the holder of the lock. It is a no-op when lockToken is
+// empty, i.e. locking is disabled or the server doesn't support it.
+//
+// An untagged list is only correct when the locked resource is the
+// Request-URI itself (DELETE, PUT); see setTaggedIfHeader for MOVE, where
+// that's not the case.
+func setIfHeader(req *http.Request, lockToken string) {
+	if lockToken == "" {
+		return
+	}
+
+	req.Header.Set("If", "(<"+lockToken+">)")
+}
+
+// setTaggedIfHeader adds an RFC 4918 tagged "If" header asserting lockToken
+// against resourceURL specifically, rather than the Request-URI. This is
+// required for MOVE: the lock is acquired on the destination, but the
+// Request-URI is the source being moved, and an untagged assertion is
+// scoped to the Request-URI, so it wouldn't reference the locked resource
+// at all. A tagged list names the resource the assertion applies to
+// explicitly, per RFC 4918 section 10.4.2. It is a no-op when lockToken is
+// empty.
+func setTaggedIfHeader(req *http.Request, resourceURL, lockToken string) {
+	if lockToken == "" {
+		return
+	}
+
+	req.Header.Set("If", "<"+resourceURL+"> (<"+lockToken+">)")
+}
+
 func (d *davStorage) PutBlock(ctx context.Context, blockID string, r io.Reader) error {
 	shardPath, url := d.getCollectionAndFileURL(blockID)
 
@@ -255,23 +292,40 @@ func (d *davStorage) PutBlock(ctx context.Context, blockID string, r io.Reader)
 	data := buf.Bytes()
 
 	tmpURL := url + "-" + makeClientNonce()
-	err = d.putBlockInternal(tmpURL, data)
+	err = d.putBlockInternal(tmpURL, data, "")
 
 	if err == storage.ErrBlockNotFound {
 		if err = d.makeCollectionAll(shardPath); err != nil {
 			return err
 		}
 
-		err = d.putBlockInternal(tmpURL, data)
+		err = d.putBlockInternal(tmpURL, data, "")
+	}
+
+	if err != nil {
+		return err
 	}
 
+	lock, err := d.lockForWrite(url)
 	if err != nil {
+		if delerr := d.delete(tmpURL, ""); delerr != nil {
+			log.Warn().Err(delerr).Msg("unable to delete temp file")
+		}
+
 		return err
 	}
 
-	if err := d.move(tmpURL, url); err != nil {
-		if delerr := d.delete(tmpURL); delerr != nil {
-			log.Warn().Err(err).Msg("unable to delete temp file")
+	if lock.held {
+		defer func() {
+			if unlockErr := d.unlock(url, lock.token); unlockErr != nil {
+				log.Warn().Err(unlockErr).Msg("unable to release WebDAV lock")
+			}
+		}()
+	}
+
+	if err := d.move(tmpURL, url, lock.token); err != nil {
+		if delerr := d.delete(tmpURL, ""); delerr != nil {
+			log.Warn().Err(delerr).Msg("unable to delete temp file")
 		}
 		return err
 	}
@@ -280,13 +334,22 @@ func (d *davStorage) PutBlock(ctx context.Context, blockID string, r io.Reader)
 }
 
 func (d *davStorage) DeleteBlock(ctx context.Context, blockID string) error {
-	_, url := d.getCollectionAndFileURL(blockID)
-	err := os.Remove(url)
-	if err == nil || os.IsNotExist(err) {
-		return nil
+	_, urlStr := d.getCollectionAndFileURL(blockID)
+
+	lock, err := d.lockForWrite(urlStr)
+	if err != nil {
+		return err
 	}
 
-	return err
+	if lock.held {
+		defer func() {
+			if unlockErr := d.unlock(urlStr, lock.token); unlockErr != nil {
+				log.Warn().Err(unlockErr).Msg("unable to release WebDAV lock")
+			}
+		}()
+	}
+
+	return d.delete(urlStr, lock.token)
 }
 
 func (d *davStorage) getCollectionURL(blockID string) (string, string) {
@@ -323,7 +386,6 @@ func (d *davStorage) Close(ctx context.Context) error {
 func New(ctx context.Context, opts *Options) (storage.Storage, error) {
 	r := &davStorage{
 		Options: *opts,
-		Client:  http.DefaultClient,
 	}
 
 	for _, s := range r.shards() {
@@ -333,6 +395,14 @@ func New(ctx context.Context, opts *Options) (storage.Storage, error) {
 	}
 
 	r.Options.URL = strings.TrimSuffix(r.Options.URL, "/")
+
+	transport, err := webdavauth.NewTransport(http.DefaultTransport, opts.Auth, &r.clientNonceCount)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up authentication: %w", err)
+	}
+
+	r.Client = &http.Client{Transport: transport}
+
 	return r, nil
 }
 
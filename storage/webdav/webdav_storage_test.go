@@ -0,0 +1,180 @@
+package webdav
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type capturedRequest struct {
+	method string
+	header http.Header
+}
+
+// newFakeWebDAVServer answers every LOCK/UNLOCK/PUT/MOVE/DELETE it receives
+// successfully, while recording each request's method and headers so tests
+// can assert on the "If" header the client actually sent.
+func newFakeWebDAVServer(t *testing.T) (*httptest.Server, func() []capturedRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []capturedRequest
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, capturedRequest{method: r.Method, header: r.Header.Clone()})
+		mu.Unlock()
+
+		switch r.Method {
+		case "LOCK":
+			w.Header().Set("Lock-Token", "<urn:lock:test-token>")
+			w.WriteHeader(http.StatusOK)
+		case "UNLOCK":
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPut, "MOVE":
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+
+	return srv, func() []capturedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]capturedRequest(nil), requests...)
+	}
+}
+
+func findRequest(requests []capturedRequest, method string) (capturedRequest, bool) {
+	for _, r := range requests {
+		if r.method == method {
+			return r, true
+		}
+	}
+
+	return capturedRequest{}, false
+}
+
+// TestPutBlockMoveUsesTaggedIfHeader verifies that the lock token acquired
+// on the destination is asserted with a tagged "If" list naming that
+// destination, not an untagged list that RFC 4918 would scope to MOVE's
+// Request-URI (the temp object), which most compliant servers would then
+// ignore as not referencing the locked resource.
+func TestPutBlockMoveUsesTaggedIfHeader(t *testing.T) {
+	srv, requestsSoFar := newFakeWebDAVServer(t)
+	defer srv.Close()
+
+	d := &davStorage{
+		Options: Options{URL: srv.URL, UseLocking: true},
+		Client:  &http.Client{},
+	}
+
+	if err := d.PutBlock(context.Background(), "abcdefgh0123456789", strings.NewReader("hello")); err != nil {
+		t.Fatalf("PutBlock failed: %v", err)
+	}
+
+	moveReq, ok := findRequest(requestsSoFar(), "MOVE")
+	if !ok {
+		t.Fatal("expected a MOVE request")
+	}
+
+	destination := moveReq.header.Get("Destination")
+	if destination == "" {
+		t.Fatal("MOVE request missing Destination header")
+	}
+
+	want := "<" + destination + "> (<urn:lock:test-token>)"
+	if got := moveReq.header.Get("If"); got != want {
+		t.Fatalf("MOVE If header = %q, want %q", got, want)
+	}
+}
+
+// TestDeleteBlockUsesUntaggedIfHeader verifies the plain DELETE case is
+// left alone: the locked resource and the Request-URI are the same there,
+// so an untagged list is correct.
+func TestDeleteBlockUsesUntaggedIfHeader(t *testing.T) {
+	srv, requestsSoFar := newFakeWebDAVServer(t)
+	defer srv.Close()
+
+	d := &davStorage{
+		Options: Options{URL: srv.URL, UseLocking: true},
+		Client:  &http.Client{},
+	}
+
+	if err := d.DeleteBlock(context.Background(), "abcdefgh0123456789"); err != nil {
+		t.Fatalf("DeleteBlock failed: %v", err)
+	}
+
+	deleteReq, ok := findRequest(requestsSoFar(), http.MethodDelete)
+	if !ok {
+		t.Fatal("expected a DELETE request")
+	}
+
+	want := "(<urn:lock:test-token>)"
+	if got := deleteReq.header.Get("If"); got != want {
+		t.Fatalf("DELETE If header = %q, want %q", got, want)
+	}
+}
+
+func TestSetIfHeader(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, "http://example.com/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setIfHeader(req, "urn:lock:abc")
+
+	if got, want := req.Header.Get("If"), "(<urn:lock:abc>)"; got != want {
+		t.Fatalf("If header = %q, want %q", got, want)
+	}
+}
+
+func TestSetIfHeaderNoopWhenTokenEmpty(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, "http://example.com/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setIfHeader(req, "")
+
+	if got := req.Header.Get("If"); got != "" {
+		t.Fatalf("expected no If header, got %q", got)
+	}
+}
+
+func TestSetTaggedIfHeader(t *testing.T) {
+	req, err := http.NewRequest("MOVE", "http://example.com/tmp-xyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTaggedIfHeader(req, "http://example.com/final", "urn:lock:abc")
+
+	if got, want := req.Header.Get("If"), "<http://example.com/final> (<urn:lock:abc>)"; got != want {
+		t.Fatalf("If header = %q, want %q", got, want)
+	}
+}
+
+func TestSetTaggedIfHeaderNoopWhenTokenEmpty(t *testing.T) {
+	req, err := http.NewRequest("MOVE", "http://example.com/tmp-xyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTaggedIfHeader(req, "http://example.com/final", "")
+
+	if got := req.Header.Get("If"); got != "" {
+		t.Fatalf("expected no If header, got %q", got)
+	}
+}
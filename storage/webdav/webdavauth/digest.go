@@ -0,0 +1,219 @@
+package webdavauth
+
+import (
+	"crypto/md5" //nolint:gosec
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// digestTransport implements RFC 7616 Digest authentication, challenging
+// once per distinct server nonce and then reusing the cached challenge
+// (incrementing the nonce count, "nc") on every subsequent request until the
+// server rejects it with a fresh 401.
+type digestTransport struct {
+	base               http.RoundTripper
+	username, password string
+
+	// nc is the shared client-nonce-count counter used to fill in the "nc"
+	// directive. It is a pointer so that a single counter (e.g.
+	// davStorage.clientNonceCount) can be shared across every request the
+	// backend makes, as RFC 7616 requires nc to be monotonically increasing
+	// per nonce rather than reset per request. When nil, a private counter
+	// is used instead.
+	nc        *int32
+	privateNC int32
+
+	mutex  sync.Mutex
+	cached *digestChallenge
+}
+
+type digestChallenge struct {
+	realm, nonce, opaque, qop, algorithm string
+}
+
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mutex.Lock()
+	challenge := t.cached
+	t.mutex.Unlock()
+
+	if challenge != nil {
+		authReq := cloneRequest(req)
+		if err := t.authorize(authReq, challenge); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.base.RoundTrip(authReq)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+
+		resp.Body.Close() //nolint:errcheck
+	}
+
+	// either we had no challenge yet, or the cached one was rejected (the
+	// server may have rotated its nonce) - issue an unauthenticated probe to
+	// harvest a fresh one.
+	probe := cloneRequest(req)
+
+	resp, err := t.base.RoundTrip(probe)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	newChallenge, err := parseDigestChallenge(resp.Header.Get("WWW-Authenticate"))
+
+	resp.Body.Close() //nolint:errcheck
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.mutex.Lock()
+	t.cached = newChallenge
+	t.mutex.Unlock()
+
+	authReq := cloneRequest(req)
+	if err := t.authorize(authReq, newChallenge); err != nil {
+		return nil, err
+	}
+
+	return t.base.RoundTrip(authReq)
+}
+
+func (t *digestTransport) authorize(req *http.Request, c *digestChallenge) error {
+	cnonce, err := makeCnonce()
+	if err != nil {
+		return err
+	}
+
+	nc := atomic.AddInt32(t.ncCounter(), 1)
+	ncStr := fmt.Sprintf("%08x", nc)
+
+	ha1 := md5Hex(t.username + ":" + c.realm + ":" + t.password)
+	ha2 := md5Hex(req.Method + ":" + req.URL.RequestURI())
+
+	qop := c.qop
+	if qop == "" {
+		qop = "auth"
+	}
+
+	response := md5Hex(strings.Join([]string{ha1, c.nonce, ncStr, cnonce, qop, ha2}, ":"))
+
+	header := fmt.Sprintf(
+		`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=%s, nc=%s, cnonce="%s", response="%s"`,
+		t.username, c.realm, c.nonce, req.URL.RequestURI(), qop, ncStr, cnonce, response)
+
+	if c.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, c.opaque)
+	}
+
+	req.Header.Set("Authorization", header)
+
+	return nil
+}
+
+func (t *digestTransport) ncCounter() *int32 {
+	if t.nc != nil {
+		return t.nc
+	}
+
+	return &t.privateNC
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s)) //nolint:gosec
+	return hex.EncodeToString(sum[:])
+}
+
+func makeCnonce() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("unable to generate cnonce: %w", err)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// parseDigestChallenge parses a "WWW-Authenticate: Digest ..." header value
+// into its directives.
+func parseDigestChallenge(header string) (*digestChallenge, error) {
+	if !strings.HasPrefix(header, "Digest ") {
+		return nil, fmt.Errorf("not a Digest challenge: %q", header)
+	}
+
+	directives := parseDirectives(strings.TrimPrefix(header, "Digest "))
+
+	c := &digestChallenge{
+		realm:     directives["realm"],
+		nonce:     directives["nonce"],
+		opaque:    directives["opaque"],
+		qop:       firstQop(directives["qop"]),
+		algorithm: directives["algorithm"],
+	}
+
+	if c.realm == "" || c.nonce == "" {
+		return nil, fmt.Errorf("incomplete Digest challenge: %q", header)
+	}
+
+	return c, nil
+}
+
+// firstQop picks the first qop value out of a possibly comma-separated list
+// such as `qop="auth,auth-int"`.
+func firstQop(qop string) string {
+	return strings.TrimSpace(strings.SplitN(qop, ",", 2)[0])
+}
+
+// parseDirectives splits a comma-separated list of key=value (or
+// key="value") pairs into a map.
+func parseDirectives(s string) map[string]string {
+	result := map[string]string{}
+
+	for _, part := range splitDirectives(s) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(kv[0])
+		value := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		result[key] = value
+	}
+
+	return result
+}
+
+// splitDirectives splits on commas that are not inside a quoted string.
+func splitDirectives(s string) []string {
+	var parts []string
+
+	var b strings.Builder
+
+	inQuotes := false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			b.WriteRune(r)
+		case r == ',' && !inQuotes:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	parts = append(parts, b.String())
+
+	return parts
+}
@@ -0,0 +1,131 @@
+package webdavauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauth2Transport obtains a bearer token via the client-credentials or
+// refresh-token grant and injects it into every request, transparently
+// fetching a new one once the cached token is close to expiry.
+type oauth2Transport struct {
+	base http.RoundTripper
+	opts *OAuth2Options
+
+	// tokenClient is used only to talk to TokenURL, never wrapped with this
+	// transport itself.
+	tokenClient *http.Client
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newOAuth2Transport(base http.RoundTripper, opts *OAuth2Options) *oauth2Transport {
+	return &oauth2Transport{
+		base:        base,
+		opts:        opts,
+		tokenClient: &http.Client{},
+	}
+}
+
+func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.tokenFor(req)
+	if err != nil {
+		return nil, err
+	}
+
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return t.base.RoundTrip(req)
+}
+
+// tokenFor returns a cached token, fetching a new one if none is cached or
+// the cached one expires within the next minute.
+func (t *oauth2Transport) tokenFor(req *http.Request) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.token != "" && time.Until(t.expiresAt) > time.Minute {
+		return t.token, nil
+	}
+
+	token, expiresIn, err := t.fetchToken(req)
+	if err != nil {
+		return "", err
+	}
+
+	t.token = token
+	t.expiresAt = time.Now().Add(expiresIn)
+
+	return t.token, nil
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Error       string `json:"error"`
+	ErrorDesc   string `json:"error_description"`
+}
+
+func (t *oauth2Transport) fetchToken(req *http.Request) (string, time.Duration, error) {
+	form := url.Values{}
+	form.Set("client_id", t.opts.ClientID)
+	form.Set("client_secret", t.opts.ClientSecret)
+
+	if len(t.opts.Scopes) > 0 {
+		form.Set("scope", strings.Join(t.opts.Scopes, " "))
+	}
+
+	if t.opts.RefreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", t.opts.RefreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+
+	httpReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, t.opts.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to build token request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.tokenClient.Do(httpReq)
+	if err != nil {
+		return "", 0, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to read token response: %w", err)
+	}
+
+	var tr oauth2TokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return "", 0, fmt.Errorf("unable to parse token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || tr.AccessToken == "" {
+		if tr.Error != "" {
+			return "", 0, fmt.Errorf("token request rejected: %s: %s", tr.Error, tr.ErrorDesc)
+		}
+
+		return "", 0, fmt.Errorf("token request failed with status %d", resp.StatusCode)
+	}
+
+	expiresIn := time.Duration(tr.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = 5 * time.Minute
+	}
+
+	return tr.AccessToken, expiresIn, nil
+}
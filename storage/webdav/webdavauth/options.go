@@ -0,0 +1,46 @@
+// Package webdavauth provides pluggable HTTP authentication for WebDAV-based
+// storage backends, so that the same plumbing can be reused if a future
+// HTTP-based backend needs it.
+package webdavauth
+
+// Type identifies which authentication scheme Options configures.
+type Type string
+
+// Supported authentication types.
+const (
+	TypeNone   Type = ""
+	TypeBasic  Type = "basic"
+	TypeDigest Type = "digest"
+	TypeBearer Type = "bearer"
+	TypeOAuth2 Type = "oauth2"
+)
+
+// Options configures how requests made by a storage backend are
+// authenticated. Exactly one of the scheme-specific field groups below is
+// used, as selected by Type.
+type Options struct {
+	Type Type `json:"type,omitempty"`
+
+	// Username/Password are used by TypeBasic and TypeDigest.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Token is the static bearer token used by TypeBearer.
+	Token string `json:"token,omitempty"`
+
+	// OAuth2 configures TypeOAuth2.
+	OAuth2 *OAuth2Options `json:"oauth2,omitempty"`
+}
+
+// OAuth2Options configures the OAuth2 client-credentials or refresh-token
+// grant used to obtain (and transparently renew) bearer tokens.
+type OAuth2Options struct {
+	TokenURL     string   `json:"tokenURL"`
+	ClientID     string   `json:"clientID,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+
+	// RefreshToken selects the refresh-token grant instead of
+	// client-credentials when set.
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
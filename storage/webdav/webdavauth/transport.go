@@ -0,0 +1,80 @@
+package webdavauth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// NewTransport wraps base so that outgoing requests carry the credentials
+// configured by opts, transparently handling 401 challenges where the
+// scheme requires it (Digest). nonceCount, when non-nil, is the caller's
+// shared client-nonce-count counter (e.g. davStorage.clientNonceCount) and is
+// only consulted for TypeDigest; callers configuring any other scheme may
+// pass nil.
+//
+// When opts is nil or opts.Type is TypeNone, base is returned unchanged.
+func NewTransport(base http.RoundTripper, opts *Options, nonceCount *int32) (http.RoundTripper, error) {
+	if opts == nil || opts.Type == TypeNone {
+		return base, nil
+	}
+
+	switch opts.Type {
+	case TypeBasic:
+		return &basicTransport{base: base, username: opts.Username, password: opts.Password}, nil
+
+	case TypeDigest:
+		return &digestTransport{base: base, username: opts.Username, password: opts.Password, nc: nonceCount}, nil
+
+	case TypeBearer:
+		return &staticBearerTransport{base: base, token: opts.Token}, nil
+
+	case TypeOAuth2:
+		if opts.OAuth2 == nil {
+			return nil, fmt.Errorf("oauth2 options must be set when Type is %q", TypeOAuth2)
+		}
+
+		return newOAuth2Transport(base, opts.OAuth2), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported auth type %q", opts.Type)
+	}
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	clone := req.Clone(req.Context())
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			clone.Body = body
+		}
+	}
+
+	return clone
+}
+
+// basicTransport adds HTTP Basic authentication to every request.
+type basicTransport struct {
+	base               http.RoundTripper
+	username, password string
+}
+
+func (t *basicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.SetBasicAuth(t.username, t.password)
+
+	return t.base.RoundTrip(req)
+}
+
+// staticBearerTransport adds a fixed "Authorization: Bearer <token>" header
+// to every request.
+type staticBearerTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *staticBearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+t.token)
+
+	return t.base.RoundTrip(req)
+}
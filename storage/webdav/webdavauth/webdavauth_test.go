@@ -0,0 +1,186 @@
+package webdavauth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func doGet(t *testing.T, client *http.Client, target string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatalf("unable to build request: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unable to read response: %v", err)
+	}
+
+	return resp.StatusCode, string(body)
+}
+
+func TestNewTransportNoAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok")) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	transport, err := NewTransport(http.DefaultTransport, nil, nil)
+	if err != nil {
+		t.Fatalf("NewTransport failed: %v", err)
+	}
+
+	status, body := doGet(t, &http.Client{Transport: transport}, srv.URL)
+	if status != http.StatusOK || body != "ok" {
+		t.Fatalf("unexpected response: %v %q", status, body)
+	}
+}
+
+func TestBasicAuthTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "alice" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte("ok")) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	transport, err := NewTransport(http.DefaultTransport, &Options{
+		Type:     TypeBasic,
+		Username: "alice",
+		Password: "secret",
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewTransport failed: %v", err)
+	}
+
+	status, body := doGet(t, &http.Client{Transport: transport}, srv.URL)
+	if status != http.StatusOK || body != "ok" {
+		t.Fatalf("unexpected response: %v %q", status, body)
+	}
+}
+
+func TestBearerAuthTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer mytoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte("ok")) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	transport, err := NewTransport(http.DefaultTransport, &Options{
+		Type:  TypeBearer,
+		Token: "mytoken",
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewTransport failed: %v", err)
+	}
+
+	status, body := doGet(t, &http.Client{Transport: transport}, srv.URL)
+	if status != http.StatusOK || body != "ok" {
+		t.Fatalf("unexpected response: %v %q", status, body)
+	}
+}
+
+func TestDigestAuthTransport(t *testing.T) {
+	const realm = "test-realm"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			w.Header().Set("WWW-Authenticate", `Digest realm="`+realm+`", qop="auth", nonce="abc123", opaque="xyz"`)
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		if !strings.Contains(auth, `username="bob"`) || !strings.Contains(auth, `nonce="abc123"`) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte("ok")) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	var nc int32
+
+	transport, err := NewTransport(http.DefaultTransport, &Options{
+		Type:     TypeDigest,
+		Username: "bob",
+		Password: "hunter2",
+	}, &nc)
+	if err != nil {
+		t.Fatalf("NewTransport failed: %v", err)
+	}
+
+	status, body := doGet(t, &http.Client{Transport: transport}, srv.URL)
+	if status != http.StatusOK || body != "ok" {
+		t.Fatalf("unexpected response: %v %q", status, body)
+	}
+
+	if nc == 0 {
+		t.Fatalf("expected shared nonce counter to have been incremented")
+	}
+}
+
+func TestOAuth2ClientCredentialsTransport(t *testing.T) {
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("unable to parse token request: %v", err)
+		}
+
+		if r.Form.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte(`{"access_token":"abc","expires_in":3600}`)) //nolint:errcheck
+	}))
+	defer tokenSrv.Close()
+
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte("ok")) //nolint:errcheck
+	}))
+	defer apiSrv.Close()
+
+	transport, err := NewTransport(http.DefaultTransport, &Options{
+		Type: TypeOAuth2,
+		OAuth2: &OAuth2Options{
+			TokenURL:     tokenSrv.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewTransport failed: %v", err)
+	}
+
+	status, body := doGet(t, &http.Client{Transport: transport}, apiSrv.URL)
+	if status != http.StatusOK || body != "ok" {
+		t.Fatalf("unexpected response: %v %q", status, body)
+	}
+}